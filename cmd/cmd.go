@@ -0,0 +1,199 @@
+// Package cmd implements the command line interface of helmhammer.
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ushitora-anqou/helmhammer/helm"
+)
+
+// Exit statuses of Main.
+const (
+	ExitOK = 0
+	// ExitError is returned when a command fails.
+	ExitError = 1
+	// ExitUsage is returned when a command is called incorrectly.
+	ExitUsage = 2
+	// ExitDiff is returned when verify finds differences.
+	ExitDiff = 3
+)
+
+// errUsage is returned by commands that are called incorrectly. The usage is
+// already printed when it is returned.
+var errUsage = errors.New("usage error")
+
+// errDiff is returned by verify when it finds differences.
+var errDiff = errors.New("the outputs differ")
+
+// errReported is returned by commands that fail after writing the error in the
+// requested format.
+var errReported = errors.New("the error is already reported")
+
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string, stdout, stderr io.Writer) error
+}
+
+func commands() []*command {
+	return []*command{
+		{
+			name:        "compile",
+			usage:       "compile [flags] CHART",
+			description: "Compile a chart into Jsonnet",
+			run:         runCompile,
+		},
+		{
+			name:        "render",
+			usage:       "render [flags] CHART",
+			description: "Render a chart through the compiled Jsonnet like helm template",
+			run:         runRender,
+		},
+		{
+			name:        "verify",
+			usage:       "verify [flags] CHART",
+			description: "Compare the output of render with helm template",
+			run:         runVerify,
+		},
+	}
+}
+
+// Main runs the command specified by args, which do not include the program
+// name, and returns the exit status.
+func Main(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return ExitUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stdout)
+		return ExitOK
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		if strings.HasPrefix(args[0], "-") {
+			fmt.Fprintf(stderr, "unknown command: %s\n", args[0])
+			printUsage(stderr)
+			return ExitUsage
+		}
+		// For backward compatibility, `helmhammer CHART` is the same as
+		// `helmhammer compile CHART`.
+		cmd = findCommand("compile")
+	} else {
+		args = args[1:]
+	}
+
+	err := cmd.run(args, stdout, stderr)
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.Is(err, errUsage):
+		return ExitUsage
+	case errors.Is(err, errDiff):
+		return ExitDiff
+	case errors.Is(err, errReported):
+		return ExitError
+	default:
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: helmhammer COMMAND [flags] CHART\n\nCommands:\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(w, "\nRun 'helmhammer COMMAND -h' for the flags of each command.\n")
+}
+
+// newFlagSet returns a flag set for cmd whose errors and usage are written to
+// stderr.
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	cmd := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: helmhammer %s\n\n%s.\n\nFlags:\n", cmd.usage, cmd.description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args with fs and returns the chart path, which must be the
+// only positional argument.
+func parseFlags(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", err
+		}
+		return "", errUsage
+	}
+	// Allow flags after the chart path as well, e.g. `render CHART -f values.yaml`.
+	rest := fs.Args()
+	if len(rest) > 1 {
+		if err := fs.Parse(rest[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return "", err
+			}
+			return "", errUsage
+		}
+		if fs.NArg() != 0 {
+			fmt.Fprintf(fs.Output(), "too many arguments: %s\n", strings.Join(fs.Args(), " "))
+			fs.Usage()
+			return "", errUsage
+		}
+	}
+	if len(rest) == 0 {
+		fmt.Fprintf(fs.Output(), "chart not specified\n")
+		fs.Usage()
+		return "", errUsage
+	}
+	return rest[0], nil
+}
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// loadFlags are the flags to load charts.
+type loadFlags struct {
+	repositoryDirs stringsFlag
+}
+
+func (f *loadFlags) register(fs *flag.FlagSet) {
+	fs.Var(&f.repositoryDirs, "repository-dir",
+		"local directory of packaged charts to resolve missing dependencies from (can be repeated)")
+}
+
+func (f *loadFlags) load(chartPath string) (*helm.RootChart, error) {
+	chart, err := helm.LoadWithOptions(chartPath, &helm.LoadOptions{RepositoryDirs: f.repositoryDirs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+	return chart, nil
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushitora-anqou/helmhammer/verify"
+)
+
+const testdataDir = "../compiler/testdata"
+
+func readExpectedManifests(t *testing.T, name string) []map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(testdataDir, name))
+	require.NoError(t, err)
+	var manifests []map[string]any
+	require.NoError(t, json.Unmarshal(data, &manifests))
+	return manifests
+}
+
+func TestMainUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, ExitUsage, Main([]string{}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "Usage: helmhammer COMMAND")
+
+	stdout.Reset()
+	stderr.Reset()
+	assert.Equal(t, ExitUsage, Main([]string{"render"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "chart not specified")
+
+	stdout.Reset()
+	stderr.Reset()
+	assert.Equal(t, ExitError, Main([]string{"render", "no-such-chart"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "Error: failed to load chart")
+}
+
+func TestCompile(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "skeleton.jsonnet")
+	var stdout, stderr bytes.Buffer
+	status := Main([]string{"compile", "-o", output, filepath.Join(testdataDir, "skeleton")}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+	assert.Empty(t, stdout.String())
+
+	compiled, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Contains(t, string(compiled), "chartMain(")
+
+	// `helmhammer CHART` is the same as `helmhammer compile CHART`.
+	stdout.Reset()
+	status = Main([]string{filepath.Join(testdataDir, "skeleton")}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+	assert.Equal(t, string(compiled), stdout.String())
+
+	stdout.Reset()
+	status = Main([]string{"compile", "--compact", filepath.Join(testdataDir, "skeleton")}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+	assert.Contains(t, stdout.String(), "chartMain(")
+	assert.Less(t, strings.Count(stdout.String(), "\n"), strings.Count(string(compiled), "\n"))
+}
+
+func TestCompileOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	status := Main([]string{"compile", "--output-dir", dir, filepath.Join(testdataDir, "skeleton")}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+	assert.Empty(t, stdout.String())
+
+	main, err := os.ReadFile(filepath.Join(dir, "main.jsonnet"))
+	require.NoError(t, err)
+	assert.Contains(t, string(main), "import 'helmhammer.libsonnet'")
+	assert.Contains(t, string(main), "import 'skeleton/templates/deployment.yaml.libsonnet'")
+	assert.FileExists(t, filepath.Join(dir, "helmhammer.libsonnet"))
+	assert.FileExists(t, filepath.Join(dir, "skeleton", "templates", "deployment.yaml.libsonnet"))
+
+	status = Main([]string{"compile", "-o", filepath.Join(dir, "a.jsonnet"), "--output-dir", dir,
+		filepath.Join(testdataDir, "skeleton")}, &stdout, &stderr)
+	assert.Equal(t, ExitUsage, status)
+}
+
+func TestCompileErrors(t *testing.T) {
+	chartPath := filepath.Join(testdataDir, "diagnostics")
+	var stdout, stderr bytes.Buffer
+	status := Main([]string{"compile", chartPath}, &stdout, &stderr)
+	require.Equal(t, ExitError, status)
+	assert.Contains(t, stderr.String(), "diagnostics/templates/a.yaml:3:17: {{break}}: break not implemented\n")
+
+	stderr.Reset()
+	status = Main([]string{"compile", "--error-format", "json", chartPath}, &stdout, &stderr)
+	require.Equal(t, ExitError, status)
+	var diagnostics []map[string]any
+	require.NoError(t, json.Unmarshal(stderr.Bytes(), &diagnostics), stderr.String())
+	assert.Len(t, diagnostics, 3)
+	assert.Equal(t, map[string]any{
+		"file":    "diagnostics/templates/a.yaml",
+		"line":    float64(3),
+		"column":  float64(17),
+		"snippet": "{{break}}",
+		"message": "break not implemented",
+	}, diagnostics[1])
+
+	stderr.Reset()
+	status = Main([]string{"compile", "--error-format", "json", "no-such-chart"}, &stdout, &stderr)
+	require.Equal(t, ExitError, status)
+	require.NoError(t, json.Unmarshal(stderr.Bytes(), &diagnostics), stderr.String())
+	require.Len(t, diagnostics, 1)
+	assert.Contains(t, diagnostics[0]["message"], "failed to load chart")
+	assert.Empty(t, stdout.String())
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOutput string
+	}{
+		{
+			name:           "skeleton",
+			args:           []string{"render", "--format", "json", filepath.Join(testdataDir, "skeleton")},
+			expectedOutput: "skeleton.expected",
+		},
+		{
+			name: "flags after the chart",
+			args: []string{
+				"render", filepath.Join(testdataDir, "release"),
+				"--format", "json", "--namespace", "ns", "--set", "password=",
+			},
+			expectedOutput: "release-0.expected",
+		},
+		{
+			name: "values files",
+			args: []string{
+				"render", "--format", "json", "--include-crds",
+				"-f", filepath.Join(testdataDir, "crds-1.values.yaml"),
+				filepath.Join(testdataDir, "crds"),
+			},
+			expectedOutput: "crds-1.expected",
+		},
+		{
+			name: "null values",
+			args: []string{
+				"render", "--format", "json", "--include-crds", filepath.Join(testdataDir, "nulls"),
+				"--set", "a.c=null,a.d=null,list=null", "--set", "sub.s1=null,sub.nested.x=null,sub.s3=null",
+			},
+			expectedOutput: "nulls-1.expected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			status := Main(tt.args, &stdout, &stderr)
+			require.Equal(t, ExitOK, status, stderr.String())
+
+			var got []map[string]any
+			require.NoError(t, json.Unmarshal(stdout.Bytes(), &got))
+			diffs, err := verify.Compare(readExpectedManifests(t, tt.expectedOutput), got, nil)
+			require.NoError(t, err)
+			assert.Empty(t, diffs)
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	status := Main([]string{"verify", filepath.Join(testdataDir, "skeleton")}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+	assert.Equal(t, "OK: 4 manifests are identical\n", stdout.String())
+
+	chartDir := "../verify/testdata/toyaml"
+	stdout.Reset()
+	status = Main([]string{"verify", chartDir}, &stdout, &stderr)
+	require.Equal(t, ExitDiff, status, stderr.String())
+	assert.Contains(t, stdout.String(), "differs: v1 ConfigMap toyaml\n  /data/config.yaml:\n")
+
+	stdout.Reset()
+	status = Main([]string{"verify", chartDir, "--yaml-path", "/data/config.yaml"}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+
+	stdout.Reset()
+	status = Main([]string{"verify", chartDir, "--ignore", "/data/config.yaml"}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+
+	ignoreFile := filepath.Join(t.TempDir(), "ignore.yaml")
+	require.NoError(t, os.WriteFile(ignoreFile, []byte(`
+- resource: v1 ConfigMap toyaml
+  patch:
+  - op: remove
+    path: /data/config.yaml
+`), 0o644))
+	stdout.Reset()
+	status = Main([]string{"verify", chartDir, "--ignore-file", ignoreFile}, &stdout, &stderr)
+	require.Equal(t, ExitOK, status, stderr.String())
+
+	require.NoError(t, os.WriteFile(ignoreFile, []byte("- unknown: field\n"), 0o644))
+	stderr.Reset()
+	status = Main([]string{"verify", chartDir, "--ignore-file", ignoreFile}, &stdout, &stderr)
+	require.Equal(t, ExitError, status)
+	assert.Contains(t, stderr.String(), "failed to parse ignore file")
+}
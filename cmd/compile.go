@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ushitora-anqou/helmhammer/compiler"
+	"github.com/ushitora-anqou/helmhammer/jsonnet"
+)
+
+func runCompile(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("compile", stderr)
+	var load loadFlags
+	load.register(fs)
+	output := fs.String("o", "", "path to write the compiled Jsonnet to instead of stdout")
+	errorFormat := fs.String("error-format", "text", "format of the errors written to stderr: text or json")
+	outputDir := fs.String("output-dir", "", "directory to write the compiled Jsonnet to as multiple files, whose entry point is main.jsonnet")
+	compact := fs.Bool("compact", false, "print the Jsonnet without line breaks instead of formatting it like jsonnetfmt")
+	chartPath, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *errorFormat != "text" && *errorFormat != "json" {
+		fmt.Fprintf(stderr, "invalid error format: %s\n", *errorFormat)
+		fs.Usage()
+		return errUsage
+	}
+	if *output != "" && *outputDir != "" {
+		fmt.Fprintf(stderr, "-o and --output-dir can't be specified together\n")
+		fs.Usage()
+		return errUsage
+	}
+
+	if *outputDir != "" {
+		files, err := compileChartFiles(&load, chartPath)
+		if err != nil {
+			if *errorFormat == "json" {
+				return writeDiagnostics(stderr, err)
+			}
+			return err
+		}
+		return writeFiles(*outputDir, files, *compact)
+	}
+
+	expr, err := compileChart(&load, chartPath)
+	if err != nil {
+		if *errorFormat == "json" {
+			return writeDiagnostics(stderr, err)
+		}
+		return err
+	}
+
+	source := expr.PrettyWithPrologue()
+	if *compact {
+		source = expr.StringWithPrologue()
+	}
+	if *output == "" {
+		_, err := fmt.Fprint(stdout, source)
+		return err
+	}
+	if err := os.WriteFile(*output, []byte(source), 0o644); err != nil {
+		return fmt.Errorf("failed to write the compiled chart: %w", err)
+	}
+	return nil
+}
+
+func compileChart(load *loadFlags, chartPath string) (*jsonnet.Expr, error) {
+	chart, err := load.load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := compiler.CompileChart(chart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile chart: %w", err)
+	}
+	return expr, nil
+}
+
+func compileChartFiles(load *loadFlags, chartPath string) (map[string]*jsonnet.Expr, error) {
+	chart, err := load.load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	files, err := compiler.CompileChartFiles(chart, &compiler.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile chart: %w", err)
+	}
+	return files, nil
+}
+
+// writeFiles writes files, which map paths relative to dir to their contents,
+// creating the directories as needed.
+func writeFiles(dir string, files map[string]*jsonnet.Expr, compact bool) error {
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		source := files[name].Pretty() + "\n"
+		if compact {
+			source = files[name].String() + "\n"
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to write the compiled chart: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			return fmt.Errorf("failed to write the compiled chart: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeDiagnostics writes err as a JSON array of compiler.Diagnostic for
+// editors and CI. An error without locations, e.g. a failure to load the
+// chart, is written as a diagnostic that has only a message.
+func writeDiagnostics(w io.Writer, err error) error {
+	var diagnostics compiler.Diagnostics
+	if !errors.As(err, &diagnostics) {
+		diagnostics = compiler.Diagnostics{{Message: err.Error()}}
+	}
+	data, marshalErr := json.MarshalIndent(diagnostics, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	if _, err := fmt.Fprintln(w, string(data)); err != nil {
+		return err
+	}
+	return errReported
+}
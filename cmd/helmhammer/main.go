@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/ushitora-anqou/helmhammer/cmd"
+)
+
+func main() {
+	os.Exit(cmd.Main(os.Args[1:], os.Stdout, os.Stderr))
+}
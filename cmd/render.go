@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ushitora-anqou/helmhammer"
+	"github.com/ushitora-anqou/helmhammer/values"
+	"sigs.k8s.io/yaml"
+)
+
+// renderFlags are the flags to render charts, which correspond to the ones of
+// helm template.
+type renderFlags struct {
+	valueFiles, jsonValues, values, stringValues, fileValues, literalValues stringsFlag
+
+	apiVersions stringsFlag
+	namespace   string
+	kubeVersion string
+	releaseName string
+	isUpgrade   bool
+	includeCrds bool
+}
+
+func (f *renderFlags) register(fs *flag.FlagSet) {
+	fs.Var(&f.valueFiles, "values", "values file in YAML, or - for stdin (can be repeated)")
+	fs.Var(&f.valueFiles, "f", "shorthand for --values")
+	fs.Var(&f.jsonValues, "set-json", "JSON values in the form of key1=jsonval1,key2=jsonval2 (can be repeated)")
+	fs.Var(&f.values, "set", "values in the form of key1=val1,key2=val2 (can be repeated)")
+	fs.Var(&f.stringValues, "set-string", "STRING values in the form of key1=val1,key2=val2 (can be repeated)")
+	fs.Var(&f.fileValues, "set-file",
+		"values read from files in the form of key1=path1,key2=path2 (can be repeated)")
+	fs.Var(&f.literalValues, "set-literal", "a literal STRING value in the form of key=val (can be repeated)")
+	fs.Var(&f.apiVersions, "api-versions", "API version added to .Capabilities.APIVersions (can be repeated)")
+	fs.StringVar(&f.namespace, "namespace", "default", "namespace of the release")
+	fs.StringVar(&f.kubeVersion, "kube-version", helmhammer.DefaultKubeVersion,
+		"Kubernetes version for .Capabilities.KubeVersion")
+	fs.StringVar(&f.releaseName, "release-name", "", "name of the release (default: the chart name)")
+	fs.BoolVar(&f.isUpgrade, "is-upgrade", false, "set .Release.IsUpgrade instead of .Release.IsInstall")
+	fs.BoolVar(&f.includeCrds, "include-crds", false, "include CRDs in the output")
+}
+
+// mergeValues merges the values given by the flags.
+func (f *renderFlags) mergeValues(stdin io.Reader) (map[string]any, error) {
+	valuesOpts := &values.Options{
+		ValueFiles:    f.valueFiles,
+		JSONValues:    f.jsonValues,
+		Values:        f.values,
+		StringValues:  f.stringValues,
+		FileValues:    f.fileValues,
+		LiteralValues: f.literalValues,
+		Stdin:         stdin,
+	}
+	return valuesOpts.MergeValues()
+}
+
+func runRender(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("render", stderr)
+	var load loadFlags
+	load.register(fs)
+	var render renderFlags
+	render.register(fs)
+	format := fs.String("format", "yaml", "output format: yaml or json")
+	chartPath, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format != "yaml" && *format != "json" {
+		fmt.Fprintf(stderr, "invalid format: %s\n", *format)
+		fs.Usage()
+		return errUsage
+	}
+
+	vals, err := render.mergeValues(os.Stdin)
+	if err != nil {
+		return err
+	}
+	artifact, err := helmhammer.Compile(chartPath, helmhammer.Options{
+		RepositoryDirs: load.repositoryDirs,
+		KubeVersion:    render.kubeVersion,
+		APIVersions:    render.apiVersions,
+	})
+	if err != nil {
+		return err
+	}
+	rendered, err := artifact.Render(context.Background(), helmhammer.RenderOptions{
+		Values:      vals,
+		Namespace:   render.namespace,
+		ReleaseName: render.releaseName,
+		IsUpgrade:   render.isUpgrade,
+		IncludeCrds: render.includeCrds,
+	})
+	if err != nil {
+		return err
+	}
+
+	manifests := make([]map[string]any, 0, len(rendered))
+	for _, manifest := range rendered {
+		manifests = append(manifests, manifest.Object)
+	}
+	return writeManifests(stdout, manifests, *format)
+}
+
+// writeManifests writes manifests in YAML documents like helm template, or in
+// a JSON array.
+func writeManifests(w io.Writer, manifests []map[string]any, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(manifests, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "yaml":
+		for _, manifest := range manifests {
+			data, err := yaml.Marshal(manifest)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New("unknown format: " + format)
+	}
+}
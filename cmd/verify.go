@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ushitora-anqou/helmhammer/verify"
+	"sigs.k8s.io/yaml"
+)
+
+func runVerify(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("verify", stderr)
+	var load loadFlags
+	load.register(fs)
+	var render renderFlags
+	render.register(fs)
+	var ignoredPaths, yamlPaths, ignoreFiles stringsFlag
+	fs.Var(&ignoredPaths, "ignore", "JSON pointer to a field to ignore in all manifests (can be repeated)")
+	fs.Var(&yamlPaths, "yaml-path",
+		"JSON pointer to a string to compare as YAML in all manifests (can be repeated)")
+	fs.Var(&ignoreFiles, "ignore-file", "YAML file of a list of ignore rules (can be repeated)")
+	chartPath, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	vals, err := render.mergeValues(os.Stdin)
+	if err != nil {
+		return err
+	}
+	opts := &verify.Options{
+		RepositoryDirs: load.repositoryDirs,
+		Values:         vals,
+		Namespace:      render.namespace,
+		ReleaseName:    render.releaseName,
+		KubeVersion:    render.kubeVersion,
+		APIVersions:    render.apiVersions,
+		IsUpgrade:      render.isUpgrade,
+		IncludeCrds:    render.includeCrds,
+	}
+	if opts.Ignore, err = ignoreRules(ignoredPaths, yamlPaths, ignoreFiles); err != nil {
+		return err
+	}
+
+	result, err := verify.Verify(chartPath, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, diff := range result.Differences {
+		fmt.Fprintln(stdout, diff.String())
+	}
+	if len(result.Differences) != 0 {
+		return errDiff
+	}
+	fmt.Fprintf(stdout, "OK: %d manifests are identical\n", result.Manifests)
+	return nil
+}
+
+// ignoreRules builds the ignore rules from --ignore, --yaml-path and
+// --ignore-file.
+func ignoreRules(ignoredPaths, yamlPaths, ignoreFiles []string) ([]verify.IgnoreRule, error) {
+	rules := []verify.IgnoreRule{}
+	for _, file := range ignoreFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ignore file %s: %w", file, err)
+		}
+		var fileRules []verify.IgnoreRule
+		if err := yaml.UnmarshalStrict(data, &fileRules); err != nil {
+			return nil, fmt.Errorf("failed to parse ignore file %s: %w", file, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	if len(ignoredPaths) != 0 || len(yamlPaths) != 0 {
+		ops := []map[string]string{}
+		for _, path := range ignoredPaths {
+			ops = append(ops, map[string]string{"op": "remove", "path": path})
+		}
+		patch, err := json.Marshal(ops)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, verify.IgnoreRule{YAMLPaths: yamlPaths, Patch: patch})
+	}
+
+	return rules, nil
+}
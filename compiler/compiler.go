@@ -1,8 +1,10 @@
 package compiler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"reflect"
 	"slices"
 	"strconv"
@@ -55,6 +57,13 @@ func compileChartMetadata(
 		return nil, nil, err
 	}
 
+	var schema any
+	if chart.Schema != nil {
+		if err := json.Unmarshal(chart.Schema, &schema); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse values.schema.json of %s: %w", chart.Name, err)
+		}
+	}
+
 	compiledFiles := map[string]*jsonnet.Expr{}
 	for name, data := range chart.Files {
 		compiledFiles[name] = &jsonnet.Expr{
@@ -63,6 +72,15 @@ func compileChartMetadata(
 		}
 	}
 
+	importValues := []*jsonnet.Expr{}
+	for _, iv := range chart.ImportValues {
+		importValues = append(importValues, jsonnet.Map(map[string]*jsonnet.Expr{
+			"subChart": jsonnet.ConvertIntoJsonnet(iv.SubChart),
+			"child":    jsonnet.ConvertIntoJsonnet(iv.Child),
+			"parent":   jsonnet.ConvertIntoJsonnet(iv.Parent),
+		}))
+	}
+
 	compiledSubCharts := []*jsonnet.Expr{}
 	for _, subChart := range chart.SubCharts {
 		var compiledSubChart *jsonnet.Expr
@@ -75,20 +93,71 @@ func compileChartMetadata(
 
 	return jsonnet.CallChartMetadata(
 		chart.Name,
-		chart.Version,
-		chart.AppVersion,
+		jsonnet.ConvertIntoJsonnet(chart.Metadata),
 		chart.TemplateBasePath,
-		chart.Condition,
+		chart.Conditions,
+		chart.Tags,
 		chart.RenderedKeys,
+		chart.NotesKey,
 		defaultValues,
+		importValues,
+		jsonnet.ConvertIntoJsonnet(schema),
 		crds,
 		compiledFiles,
 		compiledSubCharts,
+		chart.SubChartOrder,
 	), initialHeap, nil
 }
 
+// Options are the options of CompileChartWithOptions.
+type Options struct {
+	// Functions are the functions defined by the user, which map names to
+	// Jsonnet functions, e.g. `function(args) std.length(args[0])`. They are
+	// called with the list of the arguments and return the result, both of
+	// which are plain values that contain no pointers to the heap. They
+	// override the predefined functions of the same names. The chart must be
+	// loaded with their names in helm.LoadOptions.Functions.
+	Functions map[string]string
+}
+
 func CompileChart(chart *helm.RootChart) (*jsonnet.Expr, error) {
-	expr, err := Compile(chart.Template)
+	return CompileChartWithOptions(chart, &Options{})
+}
+
+// CompileChartWithOptions is the same as CompileChart, but the templates can
+// call the functions defined in opts.
+func CompileChartWithOptions(chart *helm.RootChart, opts *Options) (*jsonnet.Expr, error) {
+	c, err := compileChartParts(chart, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	chartMain := jsonnet.CallChartMain(c.capabilities, c.rootChart, c.initialHeap, c.templates)
+	if c.userFunctions == nil {
+		return chartMain, nil
+	}
+	return &jsonnet.Expr{
+		Kind: jsonnet.ELocal,
+		LocalBinds: []*jsonnet.LocalBind{
+			{Name: userFunctionsName, Body: c.userFunctions},
+		},
+		LocalBody: chartMain,
+	}, nil
+}
+
+// compiledChart is a chart compiled into the arguments of chartMain.
+type compiledChart struct {
+	capabilities, rootChart, initialHeap, templates *jsonnet.Expr
+	// userFunctions is the object of the functions defined by the user, or
+	// nil if there are none.
+	userFunctions *jsonnet.Expr
+}
+
+func compileChartParts(chart *helm.RootChart, opts *Options) (*compiledChart, error) {
+	// Only the templates that can be rendered are compiled, which omits e.g.
+	// the unused helpers of library charts.
+	reachable := reachableTemplates(chart.Template, renderedTemplates(chart))
+	expr, err := compileTemplates(chart.Template, slices.Sorted(maps.Keys(opts.Functions)), reachable)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compile template: %w", err)
 	}
@@ -105,17 +174,38 @@ func CompileChart(chart *helm.RootChart) (*jsonnet.Expr, error) {
 		convertedInitialHeap[strconv.Itoa(i)] = v
 	}
 
-	return jsonnet.CallChartMain(
-		jsonnet.ConvertIntoJsonnet(chart.Capabilities),
-		rootChart,
-		jsonnet.Map(convertedInitialHeap),
-		expr,
-	), nil
+	c := &compiledChart{
+		capabilities: jsonnet.ConvertIntoJsonnet(chart.Capabilities),
+		rootChart:    rootChart,
+		initialHeap:  jsonnet.Map(convertedInitialHeap),
+		templates:    expr,
+	}
+	if len(opts.Functions) != 0 {
+		userFunctions := map[string]*jsonnet.Expr{}
+		for name, function := range opts.Functions {
+			userFunctions[name] = &jsonnet.Expr{Kind: jsonnet.ERaw, Raw: "(" + function + ")"}
+		}
+		c.userFunctions = jsonnet.Map(userFunctions)
+	}
+	return c, nil
 }
 
+// userFunctionsName is the name of the object of the functions defined by the
+// user in the compiled Jsonnet.
+const userFunctionsName = "userFunctions"
+
 func Compile(tmpl0 *template.Template) (*jsonnet.Expr, error) {
+	return compileTemplates(tmpl0, nil, nil)
+}
+
+// compileTemplates compiles the templates in tmpl0 whose names are in
+// reachable, or all of them if reachable is nil.
+func compileTemplates(tmpl0 *template.Template, functions []string, reachable map[string]bool) (*jsonnet.Expr, error) {
 	sortedTemplates := []*template.Template{}
 	for _, tmpl := range tmpl0.Templates() {
+		if reachable != nil && !reachable[tmpl.Name()] {
+			continue
+		}
 		sortedTemplates = append(sortedTemplates, tmpl)
 	}
 	slices.SortFunc(sortedTemplates, func(l, r *template.Template) int {
@@ -123,11 +213,16 @@ func Compile(tmpl0 *template.Template) (*jsonnet.Expr, error) {
 	})
 
 	compiledTemplates := []*jsonnet.MapEntry{}
+	errs := []error{}
 	for _, tmpl := range sortedTemplates {
-		globalEnv := env.New(tmpl0)
+		globalEnv := env.New(tmpl0, functions)
 		compiledTemplate, err := compile(globalEnv, tmpl.Root)
+		errs = append(errs, globalEnv.Errors()...)
 		if err != nil {
-			return nil, err
+			errs = append(errs, err)
+		}
+		if len(errs) != 0 {
+			continue
 		}
 		compiledTemplates = append(compiledTemplates, &jsonnet.MapEntry{
 			K: &jsonnet.Expr{
@@ -138,15 +233,22 @@ func Compile(tmpl0 *template.Template) (*jsonnet.Expr, error) {
 		})
 	}
 
-	return &jsonnet.Expr{
+	if len(errs) != 0 {
+		return nil, toDiagnostics(errs)
+	}
+
+	// The templates are optimized at once so that the runtime is loaded once
+	// to evaluate the calls of the pure functions.
+	return jsonnet.Optimize(&jsonnet.Expr{
 		Kind: jsonnet.EMap,
 		Map:  compiledTemplates,
-	}, nil
+	}, pureFunctions), nil
 }
 
 func compile(e *env.T, node parse.Node) (*jsonnet.Expr, error) {
-	enhancedVSName := state.GenerateBindName() // vs + {"$": dot}
-	dotName := state.GenerateBindName()
+	e = e.WithBindNamePrefix(bindNamePrefix(node))
+	enhancedVSName := e.GenerateBindName("vs") // vs + {"$": dot}
+	dotName := e.GenerateBindName("dot")
 	enhancedE := e.WithVSAndH(
 		jsonnet.Index(enhancedVSName),
 		jsonnet.Index("h"),
@@ -175,6 +277,20 @@ func compile(e *env.T, node parse.Node) (*jsonnet.Expr, error) {
 }
 
 func compileNode(e *env.T, node parse.Node) (*jsonnet.Expr, *state.T, error) {
+	vExpr, newState, err := compileNodeWithoutDiagnostics(e.WithBindNamePrefix(bindNamePrefix(node)), node)
+	if err != nil {
+		return nil, nil, diagnose(node, err)
+	}
+	switch node.(type) {
+	case *parse.ActionNode, *parse.TemplateNode:
+		// The other nodes are blocks, whose contents have their own locations.
+		location := sourceLocation(node)
+		return jsonnet.Located(vExpr, location), newState.Located(location), nil
+	}
+	return vExpr, newState, nil
+}
+
+func compileNodeWithoutDiagnostics(e *env.T, node parse.Node) (*jsonnet.Expr, *state.T, error) {
 	switch node := node.(type) {
 	case *parse.ActionNode:
 		pipeExpr, pipeState, err := compilePipeline(e, node.Pipe)
@@ -213,7 +329,10 @@ func compileNode(e *env.T, node parse.Node) (*jsonnet.Expr, *state.T, error) {
 			) (*jsonnet.Expr, *state.T, error) {
 				vExpr, newState, err := compileNode(e, node)
 				if err != nil {
-					return nil, nil, err
+					// Skip the node to find the errors in the following ones.
+					e.ReportError(err)
+					declareVariables(e, node)
+					return acc, e.State(), nil
 				}
 				acc.List = append(acc.List, vExpr)
 				return acc, newState, err
@@ -253,7 +372,7 @@ func compileNode(e *env.T, node parse.Node) (*jsonnet.Expr, *state.T, error) {
 		}
 		return pipeState.Use(
 			func(vs, h *jsonnet.Expr) (*jsonnet.Expr, *state.T, error) {
-				tmplResultName := state.GenerateBindName()
+				tmplResultName := e.GenerateBindName("v")
 				newState := state.New(
 					[]*jsonnet.LocalBind{
 						{Name: tmplResultName, Body: &jsonnet.Expr{
@@ -313,7 +432,7 @@ func compilePipeline(e *env.T, pipe *parse.PipeNode) (*jsonnet.Expr, *state.T, e
 
 	return pipeState.Use(
 		func(vs, h *jsonnet.Expr) (*jsonnet.Expr, *state.T, error) {
-			pipeExprName := state.GenerateBindName()
+			pipeExprName := e.GenerateBindName("pipe")
 
 			assignments := []*jsonnet.MapEntry{}
 			for _, variable := range pipe.Decl {
@@ -335,7 +454,7 @@ func compilePipeline(e *env.T, pipe *parse.PipeNode) (*jsonnet.Expr, *state.T, e
 				return pipeExpr, state.New(nil, vs, h), nil
 			}
 
-			newVSName := state.GenerateBindName()
+			newVSName := e.GenerateBindName("vs")
 			return jsonnet.Index(pipeExprName), state.New(
 				[]*jsonnet.LocalBind{
 					{Name: pipeExprName, Body: pipeExpr},
@@ -348,10 +467,35 @@ func compilePipeline(e *env.T, pipe *parse.PipeNode) (*jsonnet.Expr, *state.T, e
 	)
 }
 
+// declareVariables declares the variables that node declares without compiling
+// it, so that an error in node doesn't cause errors in the uses of them.
+func declareVariables(e *env.T, node parse.Node) {
+	action, ok := node.(*parse.ActionNode)
+	if !ok || action.Pipe.IsAssign {
+		return
+	}
+	for _, decl := range action.Pipe.Decl {
+		_ = e.DefineVariable(decl.Ident[0])
+	}
+}
+
 func compileCommand(
 	env *env.T,
 	cmd *parse.CommandNode,
 	final *jsonnet.Expr,
+) (*jsonnet.Expr, *state.T, error) {
+	vExpr, newState, err := compileCommandWithoutDiagnostics(env.WithBindNamePrefix(bindNamePrefix(cmd)), cmd, final)
+	if err != nil {
+		return nil, nil, diagnose(cmd, err)
+	}
+	location := sourceLocation(cmd)
+	return jsonnet.Located(vExpr, location), newState.Located(location), nil
+}
+
+func compileCommandWithoutDiagnostics(
+	env *env.T,
+	cmd *parse.CommandNode,
+	final *jsonnet.Expr,
 ) (*jsonnet.Expr, *state.T, error) {
 	var vExpr *jsonnet.Expr
 	var err error
@@ -437,6 +581,7 @@ func compileArg(env *env.T, arg parse.Node) (*jsonnet.Expr, *state.T, error) {
 		return vExpr, env.State(), nil
 	}
 
+	env = env.WithBindNamePrefix(bindNamePrefix(arg))
 	switch node := arg.(type) {
 	case *parse.FieldNode:
 		return compileField(env, compileDot(env), node.Ident, []parse.Node{arg}, nil)
@@ -523,7 +668,7 @@ func compileField(
 				}
 			}
 
-			resultName := state.GenerateBindName()
+			resultName := e.GenerateBindName("v")
 			newState := state.New(
 				[]*jsonnet.LocalBind{
 					{Name: resultName, Body: jsonnet.CallField(
@@ -648,7 +793,7 @@ func compileFunction(
 				}
 			}
 
-			resultName := state.GenerateBindName()
+			resultName := e.GenerateBindName("v")
 
 			return jsonnet.IndexInt(resultName, 0),
 				state.New([]*jsonnet.LocalBind{
@@ -664,6 +809,11 @@ func compileFunction(
 
 	return argsState.Use(
 		func(vs, h *jsonnet.Expr) (*jsonnet.Expr, *state.T, error) {
+			if e.IsUserFunction(node.Ident) {
+				vExpr, newState := compileUserFunction(e.WithVSAndH(vs, h), node.Ident, argsExpr)
+				return vExpr, newState, nil
+			}
+
 			if vExpr, newState, ok := compilePredefinedFunctions(
 				e.WithVSAndH(vs, h),
 				node.Ident,
@@ -681,6 +831,36 @@ func compileFunction(
 	)
 }
 
+// compileUserFunction calls the function defined by the user with the
+// arguments converted into plain values, as the builtins are called.
+func compileUserFunction(
+	e *env.T,
+	ident string,
+	compiledArgs *jsonnet.Expr,
+) (*jsonnet.Expr, *state.T) {
+	resultName := e.GenerateBindName("v")
+	newState := state.New(
+		[]*jsonnet.LocalBind{{
+			Name: resultName,
+			Body: &jsonnet.Expr{
+				Kind: jsonnet.ECall,
+				CallFunc: &jsonnet.Expr{
+					Kind: jsonnet.ERaw,
+					Raw:  `callBuiltin`,
+				},
+				CallArgs: []*jsonnet.Expr{
+					e.H(),
+					jsonnet.Index(userFunctionsName, ident),
+					compiledArgs,
+				},
+			},
+		}},
+		e.VS(),
+		jsonnet.IndexInt(resultName, 0),
+	)
+	return jsonnet.IndexInt(resultName, 1), newState
+}
+
 func compileArgs(
 	e *env.T,
 	args []parse.Node,
@@ -775,9 +955,9 @@ func compileRange(e *env.T, node *parse.RangeNode) (*jsonnet.Expr, *state.T, err
 		return nil, nil, fmt.Errorf("compileRange: %w", err)
 	}
 
-	nestedVSName := state.GenerateBindName()
-	nestedHName := state.GenerateBindName()
-	dotName := state.GenerateBindName()
+	nestedVSName := e.GenerateBindName("vs")
+	nestedHName := e.GenerateBindName("h")
+	dotName := e.GenerateBindName("dot")
 
 	thenExpr, thenState, err := e.WithVSAndH(
 		jsonnet.Index(nestedVSName),
@@ -846,7 +1026,7 @@ func compileRange(e *env.T, node *parse.RangeNode) (*jsonnet.Expr, *state.T, err
 				nestedVSValue = jsonnet.AddMap(nestedVSValue, assignments)
 			}
 
-			resultName := state.GenerateBindName()
+			resultName := e.GenerateBindName("v")
 			newState := state.New(
 				[]*jsonnet.LocalBind{
 					{Name: resultName, Body: jsonnet.CallRange(
@@ -896,7 +1076,7 @@ func compileIfOrWith(
 
 			return pipeState.Use(
 				func(vs, h *jsonnet.Expr) (*jsonnet.Expr, *state.T, error) {
-					dotName := state.GenerateBindName()
+					dotName := e.GenerateBindName("dot")
 					enhancedE := e.WithVSAndH(vs, h)
 					if typ == parse.NodeWith {
 						enhancedE = enhancedE.WithDot(jsonnet.Index(dotName))
@@ -939,7 +1119,7 @@ func compileIfOrWith(
 						IfThen: thenState.Finalize(thenExpr),
 						IfElse: elseState.Finalize(elseExpr),
 					}
-					resultName := state.GenerateBindName()
+					resultName := e.GenerateBindName("v")
 					newState := state.New(
 						[]*jsonnet.LocalBind{{Name: resultName, Body: result}},
 						jsonnet.IndexInt(resultName, 1),
@@ -952,49 +1132,55 @@ func compileIfOrWith(
 	)
 }
 
+// pureFunctions are the predefined functions that take the list of the
+// arguments and return the result, both of which are plain values, without
+// the heap. jsonnet.Optimize evaluates their calls with literal arguments at
+// compile time.
+var pureFunctions = map[string]bool{
+	"add":                        true,
+	"b64enc":                     true,
+	"ceil":                       true,
+	"contains":                   true,
+	"dir":                        true,
+	"div":                        true,
+	"divf":                       true,
+	"eq":                         true,
+	"fail":                       true,
+	"gt":                         true,
+	"indent":                     true,
+	"int":                        true,
+	"int64":                      true,
+	"lower":                      true,
+	"min":                        true,
+	"mul":                        true,
+	"mulf":                       true,
+	"mustRegexReplaceAllLiteral": true,
+	"ne":                         true,
+	"nindent":                    true,
+	"print":                      true,
+	"printf":                     true,
+	"quote":                      true,
+	"regexReplaceAll":            true,
+	"regexReplaceAllLiteral":     true,
+	"replace":                    true,
+	"required":                   true,
+	"semverCompare":              true,
+	"sha256sum":                  true,
+	"squote":                     true,
+	"ternary":                    true,
+	"toString":                   true,
+	"trim":                       true,
+	"trimAll":                    true,
+	"trimSuffix":                 true,
+	"trunc":                      true,
+}
+
 func compilePredefinedFunctions(
 	e *env.T,
 	ident string,
 	compiledArgs *jsonnet.Expr,
 ) (*jsonnet.Expr, *state.T, bool) {
-	switch ident {
-	case
-		"add",
-		"b64enc",
-		"ceil",
-		"contains",
-		"dir",
-		"div",
-		"divf",
-		"eq",
-		"fail",
-		"gt",
-		"indent",
-		"int",
-		"int64",
-		"lower",
-		"min",
-		"mul",
-		"mulf",
-		"mustRegexReplaceAllLiteral",
-		"ne",
-		"nindent",
-		"print",
-		"printf",
-		"quote",
-		"regexReplaceAll",
-		"regexReplaceAllLiteral",
-		"replace",
-		"required",
-		"semverCompare",
-		"sha256sum",
-		"squote",
-		"ternary",
-		"toString",
-		"trim",
-		"trimAll",
-		"trimSuffix",
-		"trunc":
+	if pureFunctions[ident] {
 		vExpr := &jsonnet.Expr{
 			Kind:     jsonnet.ECall,
 			CallFunc: jsonnet.Index(ident),
@@ -1003,7 +1189,9 @@ func compilePredefinedFunctions(
 			},
 		}
 		return vExpr, e.State(), true
+	}
 
+	switch ident {
 	case
 		"concat",
 		"dateInZone",
@@ -1015,7 +1203,7 @@ func compilePredefinedFunctions(
 		"omit",
 		"toYaml",
 		"typeIs":
-		resultName := state.GenerateBindName()
+		resultName := e.GenerateBindName("v")
 		newState := state.New(
 			[]*jsonnet.LocalBind{{
 				Name: resultName,
@@ -1101,7 +1289,7 @@ func compilePredefinedFunctions(
 		"upper",
 		"urlParse",
 		"without":
-		resultName := state.GenerateBindName()
+		resultName := e.GenerateBindName("v")
 		newState := state.New(
 			[]*jsonnet.LocalBind{{
 				Name: resultName,
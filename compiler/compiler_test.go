@@ -4,20 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"text/template"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/go-openapi/jsonpointer"
 	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/formatter"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/ushitora-anqou/helmhammer/compiler"
-	"github.com/ushitora-anqou/helmhammer/compiler/state"
 	"github.com/ushitora-anqou/helmhammer/helm"
 	"github.com/ushitora-anqou/helmhammer/jsonnet"
 	"sigs.k8s.io/yaml"
@@ -200,15 +202,14 @@ func testCompile(t *testing.T, tmpl0 *template.Template, tests []compileTest) {
 			require.NoError(t, err)
 			expected := sb.String()
 
-			vm := gojsonnet.MakeVM()
-			vm.StringOutput = true
-			got, err := vm.EvaluateAnonymousSnippet(
-				"file.jsonnet",
-				jsonnetExpr.StringWithPrologue(),
-			)
-			require.NoError(t, err)
-			got = strings.Trim(got, "\n")
-			assert.Equal(t, expected, got)
+			for _, source := range []string{jsonnetExpr.StringWithPrologue(), jsonnetExpr.PrettyWithPrologue()} {
+				vm := gojsonnet.MakeVM()
+				vm.StringOutput = true
+				got, err := vm.EvaluateAnonymousSnippet("file.jsonnet", source)
+				require.NoError(t, err)
+				got = strings.Trim(got, "\n")
+				assert.Equal(t, expected, got)
+			}
 		})
 	}
 }
@@ -351,11 +352,28 @@ func TestCompileChartValid(t *testing.T) {
 
 	tests := []struct {
 		name, chartDir, namespace, valuesYaml, expectedOutput string
+		kubeVersion                                           string
+		isUpgrade, skipHooks, skipTests                       bool
+		apiVersions                                           []string
+		repositoryDirs                                        []string
 		patches                                               []string
 		yamlPaths                                             []string
 	}{
 		{name: "skeleton", chartDir: "skeleton", expectedOutput: "skeleton.expected"},
 
+		{
+			name:           "skeleton: skip tests",
+			chartDir:       "skeleton",
+			skipTests:      true,
+			expectedOutput: "skeleton-skiptests.expected",
+		},
+
+		{
+			name:           "skeleton: packaged",
+			chartDir:       "repository/skeleton-0.1.0.tgz",
+			expectedOutput: "skeleton.expected",
+		},
+
 		{
 			name:           "testchart",
 			chartDir:       "testchart",
@@ -363,6 +381,186 @@ func TestCompileChartValid(t *testing.T) {
 			expectedOutput: "testchart.expected",
 		},
 
+		{
+			name:           "dependencies 0: default values",
+			chartDir:       "dependencies",
+			expectedOutput: "dependencies-0.expected",
+		},
+
+		{
+			name:           "dependencies 1: some values",
+			chartDir:       "dependencies",
+			valuesYaml:     "dependencies-1.values.yaml",
+			expectedOutput: "dependencies-1.expected",
+		},
+
+		{
+			name:           "aliases 0: default values",
+			chartDir:       "aliases",
+			expectedOutput: "aliases-0.expected",
+		},
+
+		{
+			name:           "aliases 1: some values",
+			chartDir:       "aliases",
+			valuesYaml:     "aliases-1.values.yaml",
+			expectedOutput: "aliases-1.expected",
+		},
+
+		{
+			name:           "import values 0: default values",
+			chartDir:       "importvalues",
+			expectedOutput: "importvalues-0.expected",
+		},
+
+		{
+			name:           "import values 1: some values",
+			chartDir:       "importvalues",
+			valuesYaml:     "importvalues-1.values.yaml",
+			expectedOutput: "importvalues-1.expected",
+		},
+
+		{
+			name:           "library 0: default values",
+			chartDir:       "library",
+			expectedOutput: "library-0.expected",
+		},
+
+		{
+			name:           "library 1: some values",
+			chartDir:       "library",
+			valuesYaml:     "library-1.values.yaml",
+			expectedOutput: "library-1.expected",
+		},
+
+		{
+			name:           "schema 0: default values",
+			chartDir:       "schema",
+			expectedOutput: "schema-0.expected",
+		},
+
+		{
+			name:           "schema 1: some values",
+			chartDir:       "schema",
+			valuesYaml:     "schema-1.values.yaml",
+			expectedOutput: "schema-1.expected",
+		},
+
+		{
+			name:           "chart metadata 0: default values",
+			chartDir:       "chartmetadata",
+			expectedOutput: "chartmetadata-0.expected",
+		},
+
+		{
+			name:           "chart metadata 1: some values",
+			chartDir:       "chartmetadata",
+			valuesYaml:     "chartmetadata-1.values.yaml",
+			expectedOutput: "chartmetadata-1.expected",
+		},
+
+		{
+			name:           "release 0: install",
+			chartDir:       "release",
+			namespace:      "ns",
+			expectedOutput: "release-0.expected",
+		},
+
+		{
+			name:           "release 1: upgrade",
+			chartDir:       "release",
+			namespace:      "ns",
+			isUpgrade:      true,
+			expectedOutput: "release-1.expected",
+		},
+
+		{
+			name:           "capabilities 0: default",
+			chartDir:       "capabilities",
+			expectedOutput: "capabilities-0.expected",
+		},
+
+		{
+			name:           "capabilities 1: api versions",
+			chartDir:       "capabilities",
+			apiVersions:    []string{"monitoring.coreos.com/v1", "monitoring.coreos.com/v1/ServiceMonitor"},
+			expectedOutput: "capabilities-1.expected",
+		},
+
+		{
+			name:           "capabilities 2: kube version",
+			chartDir:       "capabilities",
+			kubeVersion:    "v1.29.3-gke.1200",
+			expectedOutput: "capabilities-2.expected",
+		},
+
+		{
+			name:           "hooks 0: default",
+			chartDir:       "hooks",
+			expectedOutput: "hooks-0.expected",
+		},
+
+		{
+			name:           "hooks 1: skip tests",
+			chartDir:       "hooks",
+			skipTests:      true,
+			expectedOutput: "hooks-1.expected",
+		},
+
+		{
+			name:           "hooks 2: no hooks",
+			chartDir:       "hooks",
+			skipHooks:      true,
+			expectedOutput: "hooks-2.expected",
+		},
+
+		{
+			name:           "vendoring 0: latest versions",
+			chartDir:       "vendoring",
+			repositoryDirs: []string{"repository"},
+			expectedOutput: "vendoring-0.expected",
+		},
+
+		{
+			name:           "vendoring 1: locked versions",
+			chartDir:       "vendoringlock",
+			repositoryDirs: []string{"repository"},
+			expectedOutput: "vendoring-1.expected",
+		},
+
+		{
+			name:           "vendoring 2: requirements.lock of Helm v2",
+			chartDir:       "vendoringrequirements",
+			repositoryDirs: []string{"repository"},
+			expectedOutput: "vendoring-2.expected",
+		},
+
+		{
+			name:           "crds 0: default values",
+			chartDir:       "crds",
+			expectedOutput: "crds-0.expected",
+		},
+
+		{
+			name:           "crds 1: enable a subchart",
+			chartDir:       "crds",
+			valuesYaml:     "crds-1.values.yaml",
+			expectedOutput: "crds-1.expected",
+		},
+
+		{
+			name:           "nulls 0: default values",
+			chartDir:       "nulls",
+			expectedOutput: "nulls-0.expected",
+		},
+
+		{
+			name:           "nulls 1: nulls remove default values including subcharts'",
+			chartDir:       "nulls",
+			valuesYaml:     "nulls-1.values.yaml",
+			expectedOutput: "nulls-1.expected",
+		},
+
 		{
 			name:           "topolvm 0: empty values",
 			chartDir:       "thirdparty/topolvm-15.5.4",
@@ -603,44 +801,43 @@ func TestCompileChartValid(t *testing.T) {
 				require.NoError(t, err)
 			}
 
-			chart, err := helm.Load(filepath.Join(testdataDir, tt.chartDir))
+			repositoryDirs := []string{}
+			for _, dir := range tt.repositoryDirs {
+				repositoryDirs = append(repositoryDirs, filepath.Join(testdataDir, dir))
+			}
+			chart, err := helm.LoadWithOptions(
+				filepath.Join(testdataDir, tt.chartDir),
+				&helm.LoadOptions{RepositoryDirs: repositoryDirs},
+			)
 			require.NoError(t, err)
 
-			state.ResetGenID()
 			compiledChart, err := compiler.CompileChart(chart)
 			require.NoError(t, err)
 
-			jsonnetExpr := &jsonnet.Expr{
-				Kind:     jsonnet.ECall,
-				CallFunc: compiledChart,
-				CallArgs: []*jsonnet.Expr{},
-				CallNamedArgs: []*jsonnet.NamedArg{
-					{Name: "includeCrds", Arg: &jsonnet.Expr{Kind: jsonnet.ETrue}},
-				},
-			}
+			args := map[string]any{"includeCrds": true}
 			if tt.namespace != "" {
-				jsonnetExpr.CallNamedArgs = append(jsonnetExpr.CallNamedArgs, &jsonnet.NamedArg{
-					Name: "namespace",
-					Arg:  jsonnet.ConvertIntoJsonnet(tt.namespace),
-				})
+				args["namespace"] = tt.namespace
+			}
+			if tt.kubeVersion != "" {
+				args["kubeVersion"] = tt.kubeVersion
+			}
+			if tt.apiVersions != nil {
+				args["apiVersions"] = tt.apiVersions
+			}
+			if tt.isUpgrade {
+				args["isUpgrade"] = true
+			}
+			// --no-hooks of helm template disables tests as well.
+			if tt.skipHooks {
+				args["includeHooks"] = false
+			}
+			if tt.skipHooks || tt.skipTests {
+				args["includeTests"] = false
 			}
 			if tt.valuesYaml != "" {
-				valuesYaml, err := os.ReadFile(filepath.Join(testdataDir, tt.valuesYaml))
-				require.NoError(t, err)
-				var values any
-				err = yaml.Unmarshal(valuesYaml, &values)
-				require.NoError(t, err)
-				jsonnetExpr.CallNamedArgs = append(jsonnetExpr.CallNamedArgs, &jsonnet.NamedArg{
-					Name: "values",
-					Arg:  jsonnet.ConvertIntoJsonnet(values),
-				})
+				args["values"] = readValues(t, tt.valuesYaml)
 			}
-			vm := gojsonnet.MakeVM()
-			vm.MaxStack = 2000
-			gotString, err := vm.EvaluateAnonymousSnippet(
-				"file.jsonnet",
-				jsonnetExpr.StringWithPrologue(),
-			)
+			gotString, err := evaluateChart(t, compiledChart, args)
 			require.NoError(t, err)
 			got, unpatchedGot := finalizeManifests([]byte(strings.Trim(gotString, "\n")), patch)
 
@@ -673,11 +870,15 @@ func TestCompileChartValid(t *testing.T) {
 				assert.Equal(t, expectedParsed, gotParsed)
 			}
 
-			state.ResetGenID()
 			compiledChart1, err := compiler.CompileChart(chart)
 			require.NoError(t, err)
 			assert.Equal(t, compiledChart.String(), compiledChart1.String())
 
+			pretty := compiledChart.PrettyWithPrologue()
+			formatted, err := formatter.Format("file.jsonnet", pretty, formatter.DefaultOptions())
+			require.NoError(t, err)
+			assert.Equal(t, formatted, pretty)
+
 		})
 	}
 }
@@ -689,3 +890,504 @@ func convertPathsToRemovalPatches(paths []string) []string {
 	}
 	return patches
 }
+
+// compileChart loads the chart at chartDir in testdata and compiles it.
+func compileChart(t *testing.T, chartDir string) *jsonnet.Expr {
+	t.Helper()
+	chart, err := helm.Load(filepath.Join("testdata", chartDir))
+	require.NoError(t, err)
+	compiledChart, err := compiler.CompileChart(chart)
+	require.NoError(t, err)
+	return compiledChart
+}
+
+// evaluateChart calls the compiled chart with the named arguments args, e.g.
+// {"values": ...}, and returns the JSON that it evaluates to.
+func evaluateChart(t *testing.T, compiledChart *jsonnet.Expr, args map[string]any) (string, error) {
+	t.Helper()
+	call := &jsonnet.Expr{Kind: jsonnet.ECall, CallFunc: compiledChart, CallArgs: []*jsonnet.Expr{}}
+	for _, name := range slices.Sorted(maps.Keys(args)) {
+		call.CallNamedArgs = append(call.CallNamedArgs, &jsonnet.NamedArg{
+			Name: name,
+			Arg:  jsonnet.ConvertIntoJsonnet(args[name]),
+		})
+	}
+	vm := gojsonnet.MakeVM()
+	vm.MaxStack = 2000
+	return vm.EvaluateAnonymousSnippet("file.jsonnet", call.StringWithPrologue())
+}
+
+// readValues reads the values in valuesYaml in testdata.
+func readValues(t *testing.T, valuesYaml string) any {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", valuesYaml))
+	require.NoError(t, err)
+	var values any
+	require.NoError(t, yaml.Unmarshal(data, &values))
+	return values
+}
+
+func TestLoadChartInvalidDependencies(t *testing.T) {
+	testdataDir := "testdata"
+
+	tests := []struct {
+		name, chartDir, expectedError string
+	}{
+		{
+			name:          "not found",
+			chartDir:      "vendoring",
+			expectedError: "failed to resolve dependency packaged of vendoring: packaged of version ^1.0.0 is not found in the local repositories",
+		},
+		{
+			name:          "out-of-sync lock",
+			chartDir:      "vendoring-outofsync",
+			expectedError: "the lock file of vendoring-outofsync is out of sync with its dependencies",
+		},
+		{
+			name:          "digest mismatch",
+			chartDir:      "vendoring-digest",
+			expectedError: "digest mismatch for testdata/localrepo-digest/indexed-0.2.0.tgz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := helm.Load(filepath.Join(testdataDir, tt.chartDir))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
+
+func TestCompileChartInvalidValues(t *testing.T) {
+	tests := []struct {
+		name, chartDir, valuesYaml string
+		// Helm reports schema errors in random order, so each line is checked
+		// separately.
+		expectedErrors []string
+	}{
+		{
+			name:       "schema",
+			chartDir:   "schema",
+			valuesYaml: "schema-2.values.yaml",
+			expectedErrors: []string{
+				"values don't meet the specifications of the schema(s) in the following chart(s):\nschema:\n",
+				"- replicas: Must be less than 10\n",
+				"- ports.0: Must be greater than or equal to 1\n",
+				"- ports.1: Invalid type. Expected: integer, given: string\n",
+				"- ports.3: Must be less than or equal to 65535\n",
+				"- ports: Array must have at most 3 items\n",
+				"- labels.app.kubernetes.io/name: Invalid type. Expected: string, given: integer\n",
+				"- labels.tier: String length must be less than or equal to 8\n",
+				"- image: Additional property digest is not allowed\n",
+				"- image.tag: String length must be greater than or equal to 1\n",
+				`- image.pullPolicy: image.pullPolicy must be one of the following: "Always", "IfNotPresent", "Never"` + "\n",
+				"- image.repository: Does not match pattern '^[a-z0-9]+([._/-][a-z0-9]+)*$'\n",
+				"sub:\n",
+				`- mode: mode must be one of the following: "simple", "advanced"` + "\n",
+				"- global.domain: Invalid type. Expected: string, given: integer\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiledChart := compileChart(t, tt.chartDir)
+			_, err := evaluateChart(t, compiledChart, map[string]any{"values": readValues(t, tt.valuesYaml)})
+			require.Error(t, err)
+			for _, expectedError := range tt.expectedErrors {
+				assert.Contains(t, err.Error(), expectedError)
+			}
+		})
+	}
+}
+
+func TestCompileChartDiagnostics(t *testing.T) {
+	chart, err := helm.Load("testdata/diagnostics")
+	require.NoError(t, err)
+	_, err = compiler.CompileChart(chart)
+	require.Error(t, err)
+
+	// All the errors are reported, and $x is declared despite the error.
+	var diagnostics compiler.Diagnostics
+	require.ErrorAs(t, err, &diagnostics)
+	assert.Equal(t, compiler.Diagnostics{
+		{
+			File:    "diagnostics/templates/a.yaml",
+			Line:    1,
+			Column:  16,
+			Snippet: `{{template "missing" .}}`,
+			Message: "template not found: missing",
+		},
+		{
+			File:    "diagnostics/templates/a.yaml",
+			Line:    3,
+			Column:  17,
+			Snippet: "{{break}}",
+			Message: "break not implemented",
+		},
+		{
+			File:    "diagnostics/templates/b.yaml",
+			Line:    1,
+			Column:  11,
+			Snippet: "nil",
+			Message: "nil is not a command",
+		},
+	}, diagnostics)
+	assert.Equal(t, "diagnostics/templates/a.yaml:3:17: {{break}}: break not implemented", diagnostics[1].Error())
+}
+
+func TestCompileChartLibraryTemplates(t *testing.T) {
+	// As Helm does, only the partials of library charts are parsed, so
+	// common.tier defined in charts/common/templates/manifest.yaml can't be
+	// used. cf. engine.isTemplateValid
+	chart, err := helm.Load("testdata/library")
+	require.NoError(t, err)
+	assert.Nil(t, chart.Template.Lookup("library/charts/common/templates/manifest.yaml"))
+	assert.Nil(t, chart.Template.Lookup("common.tier"))
+
+	name := "library/templates/tier.yaml"
+	_, err = chart.Template.New(name).Parse(`tier: {{ include "common.tier" . }}`)
+	require.NoError(t, err)
+	chart.RenderedKeys = append(chart.RenderedKeys, name)
+	compiledChart, err := compiler.CompileChart(chart)
+	require.NoError(t, err)
+	_, err = evaluateChart(t, compiledChart, nil)
+	assert.ErrorContains(t, err, "Field does not exist: common.tier")
+}
+
+func TestCompileBindNames(t *testing.T) {
+	compileTemplates := func(templates map[string]string) map[string]string {
+		tmpl := template.New("")
+		for name, text := range templates {
+			_, err := tmpl.New(name).Parse(text)
+			require.NoError(t, err)
+		}
+		expr, err := compiler.Compile(tmpl)
+		require.NoError(t, err)
+		compiled := map[string]string{}
+		for _, entry := range expr.Map {
+			compiled[entry.K.StringLiteral] = entry.V.String()
+		}
+		return compiled
+	}
+
+	b := "{{ if .x }}{{ .y | printf \"%v\" }}{{ end }}"
+	compiled := compileTemplates(map[string]string{"dir/templates/b.yaml": b})
+	assert.Contains(t, compiled["dir/templates/b.yaml"], "b_yaml_l1_c7_v")
+
+	// Adding a template doesn't change the others.
+	compiled1 := compileTemplates(map[string]string{"dir/templates/a.yaml": "{{ .z }}", "dir/templates/b.yaml": b})
+	assert.Equal(t, compiled["dir/templates/b.yaml"], compiled1["dir/templates/b.yaml"])
+
+	// The files whose names are sanitized into the same one have different
+	// prefixes of the bindings.
+	names := []string{"dir/templates/a-b.yaml", "dir/templates/a_b.yaml", "dir/templates/a/b.yaml", "dir/a/b.yaml"}
+	compiledAll := compileTemplates(map[string]string{names[0]: b, names[1]: b, names[2]: b, names[3]: b})
+	prefixes := map[string]bool{}
+	for _, name := range names {
+		compiled := compileTemplates(map[string]string{name: b})
+		assert.Equal(t, compiled[name], compiledAll[name], name)
+		prefix, _, _ := strings.Cut(strings.TrimPrefix(compiled[name], "function(h, "), "_l1_c1_dot")
+		prefixes[prefix] = true
+	}
+	assert.Len(t, prefixes, len(names))
+	assert.Contains(t, compileTemplates(map[string]string{names[2]: b})[names[2]], "a_b_yaml_l1_c7_v")
+}
+
+func TestCompileChartConcurrently(t *testing.T) {
+	chart, err := helm.Load("testdata/skeleton")
+	require.NoError(t, err)
+
+	results := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expr, err := compiler.CompileChart(chart)
+			assert.NoError(t, err)
+			results[i] = expr.String()
+		}()
+	}
+	wg.Wait()
+	for _, result := range results[1:] {
+		assert.Equal(t, results[0], result)
+	}
+}
+
+func TestCompileChartFiles(t *testing.T) {
+	tests := []struct {
+		chartPath string
+		functions map[string]string
+	}{
+		{chartPath: "testdata/skeleton"},
+		{
+			chartPath: "../testdata/functions",
+			functions: map[string]string{
+				"double": "function(args) args[0] * 2",
+				"lookup": "function(args) {}",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chartPath, func(t *testing.T) {
+			chart, err := helm.LoadWithOptions(tt.chartPath, &helm.LoadOptions{
+				Functions: slices.Sorted(maps.Keys(tt.functions)),
+			})
+			require.NoError(t, err)
+			opts := &compiler.Options{Functions: tt.functions}
+
+			files, err := compiler.CompileChartFiles(chart, opts)
+			require.NoError(t, err)
+			assert.Contains(t, files, compiler.MainFileName)
+			assert.Contains(t, files, jsonnet.RuntimeFileName)
+			dir := t.TempDir()
+			for name, expr := range files {
+				source := expr.Pretty() + "\n"
+				formatted, err := formatter.Format(name, source, formatter.DefaultOptions())
+				require.NoError(t, err)
+				assert.Equal(t, formatted, source, name)
+
+				path := filepath.Join(dir, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(source), 0o644))
+			}
+
+			// The files evaluate to the same as the single expression.
+			expr, err := compiler.CompileChartWithOptions(chart, opts)
+			require.NoError(t, err)
+			expected, err := evaluateChart(t, expr, nil)
+			require.NoError(t, err)
+			vm := gojsonnet.MakeVM()
+			vm.MaxStack = 2000
+			got, err := vm.EvaluateFile(filepath.Join(dir, compiler.MainFileName))
+			require.NoError(t, err)
+			assert.Equal(t, expected, got)
+		})
+	}
+}
+
+func TestCompileChartReachableTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected []string
+	}{
+		{
+			name:     "constant names",
+			expected: []string{"reachable.fullname", "reachable.labels", "reachable.name"},
+		},
+		{
+			name:     "dynamic include",
+			template: `{{ include (printf "reachable.%s" .Values.x) . }}`,
+			expected: []string{
+				"reachable.fullname", "reachable.labels", "reachable.name",
+				"reachable.unused", "reachable.unusedDependency", "reachable/templates/_helpers.tpl",
+			},
+		},
+		{
+			name:     "tpl",
+			template: `{{ tpl .Values.x . }}`,
+			expected: []string{
+				"reachable.fullname", "reachable.labels", "reachable.name",
+				"reachable.unused", "reachable.unusedDependency", "reachable/templates/_helpers.tpl",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := helm.Load("testdata/reachable")
+			require.NoError(t, err)
+			if tt.template != "" {
+				name := "reachable/templates/b.yaml"
+				_, err := chart.Template.New(name).Parse(tt.template)
+				require.NoError(t, err)
+				chart.RenderedKeys = append(chart.RenderedKeys, name)
+			}
+
+			files, err := compiler.CompileChartFiles(chart, &compiler.Options{})
+			require.NoError(t, err)
+			helpers, ok := files["reachable/templates/_helpers.tpl.libsonnet"]
+			require.True(t, ok)
+			compiled := helpers.Pretty()
+			for _, name := range []string{
+				"reachable.fullname", "reachable.labels", "reachable.name",
+				"reachable.unused", "reachable.unusedDependency", "reachable/templates/_helpers.tpl",
+			} {
+				key := fmt.Sprintf("'%s':", name)
+				if slices.Contains(tt.expected, name) {
+					assert.Contains(t, compiled, key)
+				} else {
+					assert.NotContains(t, compiled, key)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileChartNotes(t *testing.T) {
+	testdataDir := "testdata"
+
+	tests := []struct {
+		name, releaseName, chartDir, valuesYaml, namespace string
+		expectedNotes                                      string
+	}{
+		{
+			name:          "notes 0: empty values",
+			releaseName:   "notes",
+			chartDir:      "notes",
+			expectedNotes: "notes-0.notes.txt",
+		},
+		{
+			name:          "notes 1: some values",
+			releaseName:   "notes",
+			chartDir:      "notes",
+			valuesYaml:    "notes-1.values.yaml",
+			namespace:     "ns",
+			expectedNotes: "notes-1.notes.txt",
+		},
+		{
+			name:          "skeleton notes 0: empty values",
+			releaseName:   "skeleton",
+			chartDir:      "skeleton",
+			expectedNotes: "skeleton-notes-0.notes.txt",
+		},
+		{
+			name:          "skeleton notes 1: ingress",
+			releaseName:   "skeleton",
+			chartDir:      "skeleton",
+			valuesYaml:    "skeleton-notes-1.values.yaml",
+			expectedNotes: "skeleton-notes-1.notes.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]any{"releaseName": tt.releaseName, "notes": true}
+			if tt.namespace != "" {
+				args["namespace"] = tt.namespace
+			}
+			if tt.valuesYaml != "" {
+				args["values"] = readValues(t, tt.valuesYaml)
+			}
+			gotString, err := evaluateChart(t, compileChart(t, tt.chartDir), args)
+			require.NoError(t, err)
+			var got struct {
+				Manifests []map[string]any `json:"manifests"`
+				Notes     string           `json:"notes"`
+			}
+			err = json.Unmarshal([]byte(gotString), &got)
+			require.NoError(t, err)
+
+			expected, err := os.ReadFile(filepath.Join(testdataDir, tt.expectedNotes))
+			require.NoError(t, err)
+
+			// helm install prints the notes trimmed.
+			assert.Equal(t, strings.TrimSpace(string(expected)), strings.TrimSpace(got.Notes))
+			assert.NotEmpty(t, got.Manifests)
+		})
+	}
+}
+
+func TestCompileChartClassified(t *testing.T) {
+	testdataDir := "testdata"
+
+	tests := []struct {
+		name, chartDir, expectedOutput string
+	}{
+		{
+			name:           "hooks",
+			chartDir:       "hooks",
+			expectedOutput: "hooks-classified.json",
+		},
+		{
+			name:           "crds",
+			chartDir:       "crds",
+			expectedOutput: "crds-classified.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotString, err := evaluateChart(t, compileChart(t, tt.chartDir), map[string]any{"classify": true})
+			require.NoError(t, err)
+
+			expected, err := os.ReadFile(filepath.Join(testdataDir, tt.expectedOutput))
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(expected), gotString)
+		})
+	}
+}
+
+func TestCompileChartOrdered(t *testing.T) {
+	testdataDir := "testdata"
+
+	// Unlike TestCompileChartValid, the manifests are compared in the order
+	// they are output.
+	tests := []struct {
+		name, chartDir, order, expectedOutput string
+		includeCrds                           bool
+	}{
+		{
+			name:           "order 0: install",
+			chartDir:       "order",
+			order:          "install",
+			expectedOutput: "order-0.expected",
+		},
+		{
+			name:           "order 1: uninstall",
+			chartDir:       "order",
+			order:          "uninstall",
+			expectedOutput: "order-1.expected",
+		},
+		{
+			name:           "skeleton: install",
+			chartDir:       "skeleton",
+			order:          "install",
+			expectedOutput: "skeleton-ordered.expected",
+		},
+		{
+			name:           "hooks: install",
+			chartDir:       "hooks",
+			order:          "install",
+			expectedOutput: "hooks-ordered.expected",
+		},
+		{
+			name:           "crds 0: include CRDs",
+			chartDir:       "crds",
+			order:          "install",
+			includeCrds:    true,
+			expectedOutput: "crds-ordered-0.expected",
+		},
+		{
+			name:           "crds 1: exclude CRDs",
+			chartDir:       "crds",
+			order:          "install",
+			expectedOutput: "crds-ordered-1.expected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]any{"order": tt.order}
+			if tt.includeCrds {
+				args["includeCrds"] = true
+			}
+			gotString, err := evaluateChart(t, compileChart(t, tt.chartDir), args)
+			require.NoError(t, err)
+			var got []map[string]any
+			err = json.Unmarshal([]byte(gotString), &got)
+			require.NoError(t, err)
+
+			expectedSrc, err := os.ReadFile(filepath.Join(testdataDir, tt.expectedOutput))
+			require.NoError(t, err)
+			var expected []map[string]any
+			err = json.Unmarshal(expectedSrc, &expected)
+			require.NoError(t, err)
+
+			assert.Equal(t, expected, got)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// Diagnostic is an error found in a template with its location.
+type Diagnostic struct {
+	// File is the path of the template file, e.g. "mychart/templates/a.yaml".
+	File string `json:"file,omitempty"`
+	// Line and Column are 1-based. Column counts bytes.
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	// Snippet is the offending part of the template, e.g. "foo .Values.x".
+	Snippet string `json:"snippet,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d *Diagnostic) Error() string {
+	if d.File == "" {
+		return d.Message
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Snippet, d.Message)
+}
+
+// Diagnostics are all the errors found in the templates, sorted by location.
+// errors.As extracts them from the errors returned by CompileChart.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, 0, len(ds))
+	for _, d := range ds {
+		lines = append(lines, d.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diagnose attaches the location of node to err unless err already has a
+// location of a node inside node.
+func diagnose(node parse.Node, err error) error {
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return err
+	}
+	d = &Diagnostic{Message: err.Error()}
+	d.File, d.Line, d.Column, d.Snippet = nodeLocation(node)
+	return d
+}
+
+// nodeLocation returns the location of node in its template and the snippet of
+// it. line and column are 1-based.
+func nodeLocation(node parse.Node) (file string, line, column int, snippet string) {
+	// ErrorContext uses the tree of node, which every parsed node has.
+	location, context := (&parse.Tree{}).ErrorContext(node)
+	// location is "FILE:LINE:COLUMN", where COLUMN is 0-based.
+	if i := strings.LastIndex(location, ":"); i != -1 {
+		column, _ = strconv.Atoi(location[i+1:])
+		column++
+		location = location[:i]
+	}
+	if i := strings.LastIndex(location, ":"); i != -1 {
+		line, _ = strconv.Atoi(location[i+1:])
+		location = location[:i]
+	}
+	// Blocks such as if are printed with their bodies.
+	snippet, _, _ = strings.Cut(context, "\n")
+	return location, line, column, snippet
+}
+
+// sourceLocation returns the location of node for jsonnet.Expr.Location.
+func sourceLocation(node parse.Node) string {
+	file, line, column, _ := nodeLocation(node)
+	return fmt.Sprintf("%s:%d:%d", file, line, column)
+}
+
+// bindNamePrefix returns the prefix of the names of the local bindings for
+// node, e.g. "deployment_yaml_l12_c5" for the node at line 12, column 5 of
+// "mychart/templates/deployment.yaml". Different files have different
+// prefixes. cf. env.T.GenerateBindName
+func bindNamePrefix(node parse.Node) string {
+	file, line, column, _ := nodeLocation(node)
+	// The chart name and "templates" are common to most of the templates.
+	parts := strings.Split(file, "/")
+	if len(parts) > 1 {
+		parts = parts[1:]
+	}
+	parts = slices.DeleteFunc(parts, func(part string) bool { return part == "templates" })
+	name := nonIdentifierRegexp.ReplaceAllString(strings.Join(parts, "_"), "_")
+	if !isPlainTemplatePath(file) || name[0] >= '0' && name[0] <= '9' {
+		// The name may be the same as the one of another file, e.g.
+		// "a-b.yaml" and "a_b.yaml", so the hash of the path is appended.
+		// The names of plain paths never end with "__" and a hash.
+		hash := fnv.New32a()
+		hash.Write([]byte(file))
+		name = fmt.Sprintf("%s__%08x", name, hash.Sum32())
+		if name[0] >= '0' && name[0] <= '9' {
+			name = "_" + name
+		}
+	}
+	return fmt.Sprintf("%s_l%d_c%d", name, line, column)
+}
+
+var nonIdentifierRegexp = regexp.MustCompile(`[^_a-zA-Z0-9]`)
+
+// plainTemplatePathRegexp matches the paths of the templates whose names
+// given by bindNamePrefix can be turned back into the paths, e.g.
+// "mychart/templates/deployment.yaml" and
+// "mychart/charts/sub/templates/dir/_helpers.tpl". The second group is the
+// path in templates/.
+var plainTemplatePathRegexp = regexp.MustCompile(
+	`^[^/]+/(charts/[a-zA-Z0-9]+/)*templates/(([a-zA-Z0-9]+/)*_?[a-zA-Z0-9]+\.[a-zA-Z0-9]+)$`,
+)
+
+// isPlainTemplatePath reports whether file is a path of a template such that
+// bindNamePrefix gives different names to it and the other plain paths.
+func isPlainTemplatePath(file string) bool {
+	match := plainTemplatePathRegexp.FindStringSubmatch(file)
+	if match == nil {
+		return false
+	}
+	// The name "charts_sub_a_yaml" of a subchart's template must not be the
+	// one of e.g. "mychart/templates/charts/sub/a.yaml".
+	path := match[2]
+	return !strings.HasPrefix(path, "charts/") && !slices.Contains(strings.Split(path, "/"), "templates")
+}
+
+// toDiagnostics converts errs into Diagnostics. Errors without a location are
+// reported as they are.
+func toDiagnostics(errs []error) Diagnostics {
+	ds := Diagnostics{}
+	for _, err := range errs {
+		var d *Diagnostic
+		if !errors.As(err, &d) {
+			d = &Diagnostic{Message: err.Error()}
+		}
+		ds = append(ds, d)
+	}
+	slices.SortStableFunc(ds, func(l, r *Diagnostic) int {
+		if c := strings.Compare(l.File, r.File); c != 0 {
+			return c
+		}
+		if l.Line != r.Line {
+			return l.Line - r.Line
+		}
+		return l.Column - r.Column
+	})
+	return ds
+}
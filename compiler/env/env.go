@@ -56,40 +56,101 @@ func (sc *scopeT) getVariable(name string) (*variableT, bool) {
 	return sc.parent.getVariable(name)
 }
 
+// sharedT is the part of the environment shared while compiling a template.
+type sharedT struct {
+	tmpl      *template.Template
+	functions map[string]bool
+	errs      []error
+	// bindNames counts the names generated by GenerateBindName.
+	bindNames map[string]int
+}
+
 type T struct {
-	tmpl       *template.Template
+	shared     *sharedT
 	scope      *scopeT
 	vs, h, dot *jsonnet.Expr
+	// bindNamePrefix is the prefix of the names generated by
+	// GenerateBindName, which tells the node being compiled.
+	bindNamePrefix string
 }
 
-func New(tmpl *template.Template) *T {
+// New returns the environment to compile tmpl in. functions are the names of
+// the functions defined by the user.
+func New(tmpl *template.Template, functions []string) *T {
+	functionSet := map[string]bool{}
+	for _, name := range functions {
+		functionSet[name] = true
+	}
 	return &T{
-		tmpl: tmpl,
+		shared: &sharedT{
+			tmpl:      tmpl,
+			functions: functionSet,
+			bindNames: map[string]int{},
+		},
 		scope: &scopeT{
 			parent:    nil,
 			variables: map[string]*variableT{},
 		},
-		vs: jsonnet.EmptyMap(),
-		h:  jsonnet.EmptyMap(),
+		vs:             jsonnet.EmptyMap(),
+		h:              jsonnet.EmptyMap(),
+		bindNamePrefix: "t",
 	}
 }
 
 func newT(
-	tmpl *template.Template,
+	shared *sharedT,
 	scope *scopeT,
 	vs, h, dot *jsonnet.Expr,
+	bindNamePrefix string,
 ) *T {
 	return &T{
-		tmpl:  tmpl,
-		scope: scope,
-		vs:    vs,
-		h:     h,
-		dot:   dot,
+		shared:         shared,
+		scope:          scope,
+		vs:             vs,
+		h:              h,
+		dot:            dot,
+		bindNamePrefix: bindNamePrefix,
 	}
 }
 
 func (e *T) Template() *template.Template {
-	return e.tmpl
+	return e.shared.tmpl
+}
+
+// ReportError records err so that compilation can go on to find the other
+// errors.
+func (e *T) ReportError(err error) {
+	e.shared.errs = append(e.shared.errs, err)
+}
+
+// Errors returns the errors recorded by ReportError.
+func (e *T) Errors() []error {
+	return e.shared.errs
+}
+
+// IsUserFunction reports whether name is a function defined by the user.
+func (e *T) IsUserFunction(name string) bool {
+	return e.shared.functions[name]
+}
+
+// WithBindNamePrefix returns the environment to compile a node in, where
+// prefix tells the node, e.g. "deployment_yaml_l12_c5".
+func (e *T) WithBindNamePrefix(prefix string) *T {
+	return newT(e.shared, e.scope, e.vs, e.h, e.dot, prefix)
+}
+
+// GenerateBindName returns a new name of a local binding for the node being
+// compiled, e.g. "deployment_yaml_l12_c5_v" for role "v". The names depend
+// only on the node so that a change in a template doesn't rename the
+// bindings of the others.
+func (e *T) GenerateBindName(role string) string {
+	name := e.bindNamePrefix + "_" + role
+	e.shared.bindNames[name]++
+	if n := e.shared.bindNames[name]; n > 1 {
+		// Roles don't end with digits, so this doesn't collide.
+		return fmt.Sprintf("%s_%d", name, n)
+	}
+	return name
 }
 
 func (e *T) VS() *jsonnet.Expr {
@@ -105,7 +166,7 @@ func (e *T) State() *state.T {
 }
 
 func (e *T) WithVSAndH(vs *jsonnet.Expr, h *jsonnet.Expr) *T {
-	return newT(e.tmpl, e.scope, vs, h, e.dot)
+	return newT(e.shared, e.scope, vs, h, e.dot, e.bindNamePrefix)
 }
 
 func (e *T) DefineVariable(name string) error {
@@ -124,7 +185,7 @@ func (e *T) WithScope(
 	nested func(*T) (*jsonnet.Expr, *state.T, error),
 ) (*jsonnet.Expr, *state.T, error) {
 	newEnv := newT(
-		e.tmpl,
+		e.shared,
 		&scopeT{
 			parent:    e.scope,
 			variables: make(map[string]*variableT),
@@ -132,6 +193,7 @@ func (e *T) WithScope(
 		e.vs,
 		e.h,
 		e.dot,
+		e.bindNamePrefix,
 	)
 	vExpr, newState, err := nested(newEnv)
 	if err != nil {
@@ -174,7 +236,7 @@ func (e *T) WithScope(
 				return vExpr, state.New(nil, outerVS, h), nil
 			}
 
-			newVSName := state.GenerateBindName()
+			newVSName := e.GenerateBindName("vs")
 			return vExpr, state.New([]*jsonnet.LocalBind{
 				{Name: newVSName, Body: jsonnet.AddMap(outerVS, assignedVars)},
 			}, jsonnet.Index(newVSName), h), nil
@@ -183,7 +245,7 @@ func (e *T) WithScope(
 }
 
 func (e *T) WithDot(expr *jsonnet.Expr) *T {
-	return newT(e.tmpl, e.scope, e.vs, e.h, expr)
+	return newT(e.shared, e.scope, e.vs, e.h, expr, e.bindNamePrefix)
 }
 
 func (e *T) Dot() *jsonnet.Expr {
@@ -0,0 +1,101 @@
+package compiler
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/ushitora-anqou/helmhammer/helm"
+	"github.com/ushitora-anqou/helmhammer/jsonnet"
+)
+
+const (
+	// MainFileName is the name of the file that CompileChartFiles outputs
+	// as the entry point.
+	MainFileName = "main.jsonnet"
+	// chartFileName is the name of the file of the metadata and the values
+	// of the charts.
+	chartFileName = "chart.libsonnet"
+	// functionsFileName is the name of the file of the functions defined by
+	// the user.
+	functionsFileName = "functions.libsonnet"
+	// templateFileSuffix is appended to the paths of the template files,
+	// e.g. "mychart/templates/a.yaml.libsonnet".
+	templateFileSuffix = ".libsonnet"
+)
+
+// CompileChartFiles is the same as CompileChartWithOptions, but splits the
+// output into files, which map paths relative to the output directory to
+// their contents:
+//
+//   - main.jsonnet evaluates to the same function as CompileChartWithOptions.
+//   - helmhammer.libsonnet is the runtime, which is the same for all charts.
+//     cf. jsonnet.Runtime
+//   - chart.libsonnet has the metadata and the values of the charts.
+//   - functions.libsonnet has the functions in opts.Functions if any.
+//   - A template file such as mychart/templates/a.yaml has
+//     mychart/templates/a.yaml.libsonnet, which has the templates defined in
+//     it.
+func CompileChartFiles(chart *helm.RootChart, opts *Options) (map[string]*jsonnet.Expr, error) {
+	c, err := compileChartParts(chart, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*jsonnet.Expr{
+		jsonnet.RuntimeFileName: jsonnet.Runtime(),
+		chartFileName: jsonnet.ImportRuntime(jsonnet.Map(map[string]*jsonnet.Expr{
+			"capabilities": c.capabilities,
+			"chart":        c.rootChart,
+			"heap":         c.initialHeap,
+		}), chartFileName),
+	}
+	if c.userFunctions != nil {
+		files[functionsFileName] = c.userFunctions
+	}
+
+	// Named templates are defined in the files that define them.
+	templatesByFile := map[string][]*jsonnet.MapEntry{}
+	for _, entry := range c.templates.Map {
+		name := entry.K.StringLiteral
+		file := name
+		if tmpl := chart.Template.Lookup(name); tmpl != nil && tmpl.Tree != nil && tmpl.Tree.ParseName != "" {
+			file = tmpl.Tree.ParseName
+		}
+		templatesByFile[file] = append(templatesByFile[file], entry)
+	}
+	templates := []*jsonnet.Expr{}
+	for _, file := range slices.Sorted(maps.Keys(templatesByFile)) {
+		path := file + templateFileSuffix
+		expr := &jsonnet.Expr{Kind: jsonnet.EMap, Map: templatesByFile[file]}
+		if expr.Identifiers()[userFunctionsName] {
+			expr = &jsonnet.Expr{
+				Kind: jsonnet.ELocal,
+				LocalBinds: []*jsonnet.LocalBind{{
+					Name: userFunctionsName,
+					Body: jsonnet.Import(jsonnet.RelativePath(path, functionsFileName)),
+				}},
+				LocalBody: expr,
+			}
+		}
+		files[path] = jsonnet.ImportRuntime(expr, path)
+		templates = append(templates, jsonnet.Import(path))
+	}
+
+	files[MainFileName] = jsonnet.ImportRuntime(&jsonnet.Expr{
+		Kind:       jsonnet.ELocal,
+		LocalBinds: []*jsonnet.LocalBind{{Name: "chart", Body: jsonnet.Import(chartFileName)}},
+		LocalBody: jsonnet.CallChartMain(
+			jsonnet.Index("chart", "capabilities"),
+			jsonnet.Index("chart", "chart"),
+			jsonnet.Index("chart", "heap"),
+			// The templates call each other by $, which is the merged object.
+			&jsonnet.Expr{
+				Kind:     jsonnet.ECall,
+				CallFunc: jsonnet.Index("mergeObjects"),
+				CallArgs: []*jsonnet.Expr{{Kind: jsonnet.EList, List: templates}},
+			},
+		),
+	}, MainFileName)
+
+	return files, nil
+}
@@ -0,0 +1,131 @@
+package compiler
+
+import (
+	"slices"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/ushitora-anqou/helmhammer/helm"
+)
+
+// renderedTemplates returns the names of the templates that chartMain renders,
+// i.e., the manifests of the charts and the release notes of the root chart.
+// Whether the subcharts are enabled depends on the values, so all of them are
+// included.
+func renderedTemplates(chart *helm.RootChart) []string {
+	names := []string{}
+	if chart.NotesKey != "" {
+		names = append(names, chart.NotesKey)
+	}
+	charts := []*helm.Chart{chart.Chart}
+	for len(charts) != 0 {
+		c := charts[len(charts)-1]
+		charts = charts[:len(charts)-1]
+		names = append(names, c.RenderedKeys...)
+		charts = append(charts, c.SubCharts...)
+	}
+	return names
+}
+
+// reachableTemplates returns the names of the templates that can be executed
+// while rendering the templates named roots, following {{template}} and
+// include with constant names. It returns nil, which means all the templates,
+// if a reachable template can execute a template chosen at runtime, i.e., by
+// include with a non-constant name or by tpl.
+func reachableTemplates(tmpl0 *template.Template, roots []string) map[string]bool {
+	reachable := map[string]bool{}
+	queue := slices.Clone(roots)
+	for len(queue) != 0 {
+		name := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if reachable[name] {
+			continue
+		}
+		reachable[name] = true
+		// A template not found is reported when its caller is compiled.
+		tmpl := tmpl0.Lookup(name)
+		if tmpl == nil || tmpl.Tree == nil {
+			continue
+		}
+		names, ok := calledTemplates(tmpl.Root)
+		if !ok {
+			return nil
+		}
+		queue = append(queue, names...)
+	}
+	return reachable
+}
+
+// calledTemplates returns the names of the templates that node executes. ok is
+// false if node can execute a template whose name is unknown until runtime.
+func calledTemplates(node parse.Node) (names []string, ok bool) {
+	ok = true
+	var walk func(node parse.Node)
+	walkBranch := func(node *parse.BranchNode) {
+		walk(node.Pipe)
+		walk(node.List)
+		walk(node.ElseList)
+	}
+	walk = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			if node == nil {
+				return
+			}
+			for _, n := range node.Nodes {
+				walk(n)
+			}
+
+		case *parse.ActionNode:
+			walk(node.Pipe)
+
+		case *parse.IfNode:
+			walkBranch(&node.BranchNode)
+
+		case *parse.RangeNode:
+			walkBranch(&node.BranchNode)
+
+		case *parse.WithNode:
+			walkBranch(&node.BranchNode)
+
+		case *parse.TemplateNode:
+			names = append(names, node.Name)
+			walk(node.Pipe)
+
+		case *parse.PipeNode:
+			if node == nil {
+				return
+			}
+			for _, cmd := range node.Cmds {
+				walk(cmd)
+			}
+
+		case *parse.CommandNode:
+			if ident, isIdent := node.Args[0].(*parse.IdentifierNode); isIdent {
+				switch ident.Ident {
+				case "include":
+					// The name can also be the result of the previous command,
+					// e.g. {{ "name" | include }}.
+					if len(node.Args) > 1 {
+						if name, isString := node.Args[1].(*parse.StringNode); isString {
+							names = append(names, name.Text)
+							break
+						}
+					}
+					ok = false
+				case "tpl":
+					// The template given to tpl can include any template.
+					ok = false
+				}
+			}
+			for _, arg := range node.Args {
+				walk(arg)
+			}
+
+		case *parse.ChainNode:
+			walk(node.Node)
+		}
+	}
+	walk(node)
+	return names, ok
+}
@@ -2,7 +2,6 @@ package state
 
 import (
 	"errors"
-	"fmt"
 	"slices"
 
 	"github.com/ushitora-anqou/helmhammer/jsonnet"
@@ -58,21 +57,23 @@ func (t *T) Finalize(v *jsonnet.Expr) *jsonnet.Expr {
 	}
 }
 
-func (t *T) PrependLocalBind(b *jsonnet.LocalBind) {
-	t.localBinds = slices.Concat([]*jsonnet.LocalBind{b}, t.localBinds)
-}
-
-var nextGenID = 0
-
-func ResetGenID() {
-	nextGenID = 0
-}
-
-func genid() int {
-	nextGenID++
-	return nextGenID
+// Located returns a copy of t whose local binds have location.
+// cf. jsonnet.Located
+func (t *T) Located(location string) *T {
+	localBinds := make([]*jsonnet.LocalBind, 0, len(t.localBinds))
+	for _, b := range t.localBinds {
+		localBinds = append(localBinds, &jsonnet.LocalBind{
+			Name: b.Name,
+			Body: jsonnet.Located(b.Body, location),
+		})
+	}
+	return &T{
+		localBinds: localBinds,
+		vs:         t.vs,
+		h:          t.h,
+	}
 }
 
-func GenerateBindName() string {
-	return fmt.Sprintf("t%d", genid())
+func (t *T) PrependLocalBind(b *jsonnet.LocalBind) {
+	t.localBinds = slices.Concat([]*jsonnet.LocalBind{b}, t.localBinds)
 }
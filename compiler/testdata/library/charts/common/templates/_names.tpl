@@ -0,0 +1,6 @@
+{{- define "common.names.name" -}}
+{{ .Chart.Name }}
+{{- end }}
+{{- define "common.names.fullname" -}}
+{{ .Release.Name }}-{{ include "common.names.name" . }}
+{{- end }}
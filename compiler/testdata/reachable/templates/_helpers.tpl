@@ -0,0 +1,15 @@
+{{- define "reachable.name" -}}
+{{ .Chart.Name }}
+{{- end }}
+{{- define "reachable.fullname" -}}
+{{ .Release.Name }}-{{ include "reachable.name" . }}
+{{- end }}
+{{- define "reachable.labels" -}}
+app: {{ template "reachable.name" . }}
+{{- end }}
+{{- define "reachable.unused" -}}
+{{ include "reachable.unusedDependency" . }}
+{{- end }}
+{{- define "reachable.unusedDependency" -}}
+unused
+{{- end }}
@@ -0,0 +1,301 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"sigs.k8s.io/yaml"
+)
+
+var errChartNotFound = errors.New("chart not found")
+
+// repositoryIndex is the part of index.yaml of a chart repository that is
+// needed to find charts. cf. repo.IndexFile
+type repositoryIndex struct {
+	Entries map[string][]struct {
+		Version string   `json:"version"`
+		URLs    []string `json:"urls"`
+		Digest  string   `json:"digest"`
+	} `json:"entries"`
+}
+
+// LoadOptions is the options of LoadWithOptions.
+type LoadOptions struct {
+	// RepositoryDirs are local directories of packaged charts that the
+	// dependencies missing from charts/ are resolved from. If a directory has
+	// index.yaml, the charts are looked up in it.
+	RepositoryDirs []string
+	// Functions are the names of the functions defined by the user, which
+	// the templates are allowed to call in addition to the predefined ones.
+	// cf. compiler.Options.Functions
+	Functions []string
+}
+
+// resolveDependencies adds the dependencies of chart that are missing from
+// charts/, without accessing the network. A dependency whose repository is
+// file:// is loaded from the chart directory or the repository index there,
+// which is relative to chartPath. The others are looked up in the local
+// repositories. If the chart has Chart.lock, the locked versions are used.
+// cf. downloader.Manager.Build
+func resolveDependencies(chart *helmchart.Chart, chartPath string, opts *LoadOptions) error {
+	hasDependency := func(name string) bool {
+		return slices.ContainsFunc(chart.Dependencies(), func(dep *helmchart.Chart) bool {
+			return dep.Name() == name
+		})
+	}
+
+	missing := false
+	for _, req := range chart.Metadata.Dependencies {
+		if req != nil && !hasDependency(req.Name) {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	var lock []*helmchart.Dependency
+	if chart.Lock != nil {
+		if err := verifyLock(chart); err != nil {
+			return err
+		}
+		lock = chart.Lock.Dependencies
+	}
+
+	baseDir := chartPath
+	if info, err := os.Stat(chartPath); err != nil {
+		return err
+	} else if !info.IsDir() {
+		baseDir = filepath.Dir(chartPath)
+	}
+
+	for i, req := range chart.Metadata.Dependencies {
+		// Dependencies that have aliases may share the same chart.
+		if req == nil || hasDependency(req.Name) {
+			continue
+		}
+		version := req.Version
+		if locked := findLockedDependency(lock, i, req); locked != nil {
+			version = locked.Version
+		}
+		dep, depPath, err := findDependency(req, version, baseDir, opts)
+		if err != nil {
+			return fmt.Errorf("failed to resolve dependency %s of %s: %w", req.Name, chart.Name(), err)
+		}
+		if err := resolveDependencies(dep, depPath, opts); err != nil {
+			return err
+		}
+		chart.AddDependency(dep)
+	}
+
+	return nil
+}
+
+// verifyLock checks that the digest in Chart.lock matches the dependencies in
+// Chart.yaml. The lock of an apiVersion v1 chart, i.e., requirements.lock,
+// may have the digest of Helm v2 instead. cf. resolver.HashReq,
+// resolver.HashV2Req
+func verifyLock(chart *helmchart.Chart) error {
+	data, err := json.Marshal([2][]*helmchart.Dependency{chart.Metadata.Dependencies, chart.Lock.Dependencies})
+	if err != nil {
+		return err
+	}
+	if "sha256:"+sha256Hex(data) == chart.Lock.Digest {
+		return nil
+	}
+	if chart.Metadata.APIVersion == helmchart.APIVersionV1 {
+		data, err := json.Marshal(map[string][]*helmchart.Dependency{"dependencies": chart.Metadata.Dependencies})
+		if err != nil {
+			return err
+		}
+		if "sha256:"+sha256Hex(data) == chart.Lock.Digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("the lock file of %s is out of sync with its dependencies", chart.Name())
+}
+
+// findLockedDependency returns the entry of lock for the i-th dependency req.
+func findLockedDependency(lock []*helmchart.Dependency, i int, req *helmchart.Dependency) *helmchart.Dependency {
+	if i < len(lock) && lock[i] != nil && lock[i].Name == req.Name {
+		return lock[i]
+	}
+	for _, locked := range lock {
+		if locked != nil && locked.Name == req.Name && locked.Repository == req.Repository {
+			return locked
+		}
+	}
+	return nil
+}
+
+// findDependency loads the chart of req whose version satisfies version. It
+// also returns the path the chart is loaded from.
+func findDependency(
+	req *helmchart.Dependency,
+	version, baseDir string,
+	opts *LoadOptions,
+) (*helmchart.Chart, string, error) {
+	if dir, ok := strings.CutPrefix(req.Repository, "file://"); ok {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(baseDir, dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.yaml")); err == nil {
+			return findInIndex(dir, req.Name, version)
+		}
+		chart, err := loader.Load(dir)
+		if err != nil {
+			return nil, "", err
+		}
+		if chart.Name() != req.Name || !satisfies(chart.Metadata.Version, version) {
+			return nil, "", fmt.Errorf("%s does not have %s of version %s", dir, req.Name, version)
+		}
+		return chart, dir, nil
+	}
+
+	for _, dir := range opts.RepositoryDirs {
+		chart, chartPath, err := findInRepository(dir, req.Name, version)
+		if errors.Is(err, errChartNotFound) {
+			continue
+		}
+		return chart, chartPath, err
+	}
+	return nil, "", fmt.Errorf("%s of version %s is not found in the local repositories", req.Name, version)
+}
+
+// findInRepository loads the chart of name whose version is the highest of the
+// ones that satisfy version in the local repository dir.
+func findInRepository(dir, name, version string) (*helmchart.Chart, string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "index.yaml")); err == nil {
+		return findInIndex(dir, name, version)
+	}
+
+	// Packaged charts are named <name>-<version>.tgz.
+	files, err := filepath.Glob(filepath.Join(dir, name+"-*.tgz"))
+	if err != nil {
+		return nil, "", err
+	}
+	var found string
+	var foundVersion *semver.Version
+	for _, file := range files {
+		v, err := semver.NewVersion(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), name+"-"), ".tgz"))
+		if err != nil || !satisfies(v.Original(), version) {
+			continue
+		}
+		if foundVersion == nil || v.GreaterThan(foundVersion) {
+			found, foundVersion = file, v
+		}
+	}
+	if found == "" {
+		return nil, "", errChartNotFound
+	}
+
+	chart, err := loader.Load(found)
+	if err != nil {
+		return nil, "", err
+	}
+	if chart.Name() != name {
+		return nil, "", fmt.Errorf("%s is not a chart of %s", found, name)
+	}
+	return chart, found, nil
+}
+
+// findInIndex loads the chart of name whose version is the highest of the
+// ones that satisfy version in the repository index in dir. Only the packaged
+// charts in dir are available, and they are verified with the digests in the
+// index. cf. repo.IndexFile.Get
+func findInIndex(dir, name, version string) (*helmchart.Chart, string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.yaml"))
+	if err != nil {
+		return nil, "", err
+	}
+	var index repositoryIndex
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return nil, "", fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, "index.yaml"), err)
+	}
+
+	var urls []string
+	var digest string
+	var foundVersion *semver.Version
+	for _, entry := range index.Entries[name] {
+		v, err := semver.NewVersion(entry.Version)
+		if err != nil || !satisfies(entry.Version, version) {
+			continue
+		}
+		// An exact match is preferred.
+		if entry.Version == version {
+			urls, digest = entry.URLs, entry.Digest
+			break
+		}
+		if foundVersion == nil || v.GreaterThan(foundVersion) {
+			urls, digest, foundVersion = entry.URLs, entry.Digest, v
+		}
+	}
+	if urls == nil {
+		if foundVersion == nil {
+			return nil, "", errChartNotFound
+		}
+		return nil, "", fmt.Errorf("no URL for %s-%s in %s", name, foundVersion.Original(), dir)
+	}
+
+	u, err := url.Parse(urls[0])
+	if err != nil {
+		return nil, "", err
+	}
+	file := filepath.Join(dir, path.Base(u.Path))
+	if u.Scheme == "" || u.Scheme == "file" {
+		file = filepath.FromSlash(u.Path)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+	}
+
+	if digest != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, "", err
+		}
+		if actual := sha256Hex(data); actual != digest {
+			return nil, "", fmt.Errorf("digest mismatch for %s: expected %s, got %s", file, digest, actual)
+		}
+	}
+
+	chart, err := loader.Load(file)
+	if err != nil {
+		return nil, "", err
+	}
+	return chart, file, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// satisfies returns true if version satisfies the constraint. An empty
+// constraint is satisfied by any version.
+func satisfies(version, constraint string) bool {
+	if constraint == "" {
+		constraint = "*"
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return c.Check(v)
+}
@@ -1,7 +1,7 @@
 package helm
 
 import (
-	"errors"
+	"fmt"
 	"maps"
 	"path"
 	"slices"
@@ -17,15 +17,30 @@ import (
 
 type Chart struct {
 	Name             string
-	Version          string
-	AppVersion       string
+	Library          bool
+	Metadata         *helmchart.Metadata
 	TemplateBasePath string
-	Condition        string
+	Conditions       []string
+	Tags             []string
+	ImportValues     []ImportValue
 	RenderedKeys     []string
+	NotesKey         string
 	Values           map[string]any
+	Schema           []byte
 	CRDObjects       []helmchart.CRD
 	Files            map[string][]byte
 	SubCharts        []*Chart
+	// SubChartOrder is the names of SubCharts in the order Helm processes the
+	// dependencies, in which CRDs are output.
+	SubChartOrder []string
+}
+
+// ImportValue is an entry of `import-values` of a dependency. It copies the
+// table at Child in the values of SubChart into Parent of the chart's values.
+type ImportValue struct {
+	SubChart string
+	Child    string
+	Parent   string
 }
 
 type RootChart struct {
@@ -37,20 +52,37 @@ type RootChart struct {
 func loadChartsRecursively(
 	tmpls *template.Template,
 	chart *helmchart.Chart,
+	name string,
+	basePath string,
 ) (*Chart, error) {
-	basePath := chart.ChartFullPath()
+	// Library charts only provide named templates in their partials. Helm
+	// drops the other templates before parsing them, so they aren't rendered
+	// and the templates defined in them can't be included.
+	// cf. engine.recAllTpls, engine.isTemplateValid
+	library := isLibraryChart(chart)
+	templates := []*helmchart.File{}
 	for _, tmpl := range chart.Templates {
-		if tmpl == nil {
+		if tmpl == nil || library && !strings.HasPrefix(path.Base(tmpl.Name), "_") {
 			continue
 		}
+		templates = append(templates, tmpl)
+	}
+
+	for _, tmpl := range templates {
 		if _, err := tmpls.New(path.Join(basePath, tmpl.Name)).Parse(string(tmpl.Data)); err != nil {
 			return nil, err
 		}
 	}
 
+	// NOTES.txt is not a manifest. Only the one right under templates/ is
+	// rendered as the release notes. cf. action.Configuration.renderResources
 	keys := []string{}
-	for _, tmpl := range chart.Templates {
+	notesKey := ""
+	for _, tmpl := range templates {
 		filename := path.Join(basePath, tmpl.Name)
+		if filename == path.Join(basePath, "templates", "NOTES.txt") {
+			notesKey = filename
+		}
 		if strings.HasPrefix(path.Base(tmpl.Name), "_") ||
 			strings.HasSuffix(filename, "NOTES.txt") {
 			continue
@@ -64,50 +96,218 @@ func loadChartsRecursively(
 		files[file.Name] = file.Data
 	}
 
+	loadSubChart := func(dep *helmchart.Chart, name string) (*Chart, error) {
+		return loadChartsRecursively(tmpls, dep, name, path.Join(basePath, "charts", name))
+	}
+
+	// Each dependency in Chart.yaml that matches a subchart is a separate
+	// instance of it, which is named after its alias if any. The other
+	// subcharts are rendered as they are. cf. chartutil.ProcessDependencies
 	subCharts := []*Chart{}
 	for _, dep := range chart.Dependencies() {
-		subChart, err := loadChartsRecursively(tmpls, dep)
+		if slices.ContainsFunc(chart.Metadata.Dependencies, func(req *helmchart.Dependency) bool {
+			return isDependencyOf(dep, req)
+		}) {
+			continue
+		}
+		subChart, err := loadSubChart(dep, dep.Name())
 		if err != nil {
 			return nil, err
 		}
 		subCharts = append(subCharts, subChart)
 	}
-	for _, dep := range chart.Metadata.Dependencies {
-		index := slices.IndexFunc(subCharts, func(c *Chart) bool {
-			return c.Name == dep.Name
+	for _, req := range chart.Metadata.Dependencies {
+		if req == nil {
+			continue
+		}
+		if !slices.ContainsFunc(chart.Dependencies(), func(dep *helmchart.Chart) bool {
+			return dep.Name() == req.Name
+		}) {
+			return nil, fmt.Errorf("invalid helm chart: missing dependency: %s", req.Name)
+		}
+		index := slices.IndexFunc(chart.Dependencies(), func(dep *helmchart.Chart) bool {
+			return isDependencyOf(dep, req)
 		})
 		if index == -1 {
-			return nil, errors.New("invalid helm chart: missing dependency")
+			continue
 		}
-		subCharts[index].Condition = dep.Condition
+		subChart, err := loadSubChart(chart.Dependencies()[index], dependencyName(req))
+		if err != nil {
+			return nil, err
+		}
+		subCharts = append(subCharts, subChart)
+	}
+	importValues := []ImportValue{}
+	for _, req := range chart.Metadata.Dependencies {
+		if req == nil {
+			continue
+		}
+		importValues = append(importValues, convertImportValues(req)...)
+		for _, subChart := range subCharts {
+			// Library charts are always available to be included.
+			if subChart.Name == dependencyName(req) && !subChart.Library {
+				subChart.Conditions = splitConditions(req.Condition)
+				subChart.Tags = req.Tags
+			}
+		}
+	}
+	subChartOrder := []string{}
+	for _, subChart := range subCharts {
+		subChartOrder = append(subChartOrder, subChart.Name)
 	}
 	slices.SortFunc(subCharts, func(l, r *Chart) int {
 		return strings.Compare(l.Name, r.Name)
 	})
 
+	values := chart.Values
+	if values == nil {
+		values = map[string]any{}
+	}
+
 	return &Chart{
 		RenderedKeys:     keys,
-		Values:           chart.Values,
-		Name:             chart.Metadata.Name,
-		Version:          chart.Metadata.Version,
-		AppVersion:       chart.Metadata.AppVersion,
-		CRDObjects:       chart.CRDObjects(),
-		TemplateBasePath: path.Join(chart.ChartFullPath(), "templates"),
+		NotesKey:         notesKey,
+		Values:           values,
+		Schema:           chart.Schema,
+		Library:          library,
+		Name:             name,
+		Metadata:         chartMetadata(chart, name),
+		CRDObjects:       crdObjects(chart, basePath),
+		TemplateBasePath: path.Join(basePath, "templates"),
 		Files:            files,
 		SubCharts:        subCharts,
-		Condition:        "",
+		SubChartOrder:    subChartOrder,
+		ImportValues:     importValues,
 	}, nil
 }
 
-func Load(chartDir string) (*RootChart, error) {
-	chart, err := loader.Load(chartDir)
+// crdObjects returns the CRDs in crds/ of chart. Unlike chart.CRDObjects, the
+// ones of the subcharts are not included because they are output only if the
+// subcharts are enabled.
+func crdObjects(chart *helmchart.Chart, basePath string) []helmchart.CRD {
+	crds := []helmchart.CRD{}
+	for _, file := range chart.Files {
+		if !strings.HasPrefix(file.Name, "crds/") {
+			continue
+		}
+		// cf. chart.hasManifestExtension
+		ext := path.Ext(file.Name)
+		if !strings.EqualFold(ext, ".yaml") && !strings.EqualFold(ext, ".yml") && !strings.EqualFold(ext, ".json") {
+			continue
+		}
+		crds = append(crds, helmchart.CRD{
+			Name:     file.Name,
+			Filename: path.Join(basePath, file.Name),
+			File:     file,
+		})
+	}
+	return crds
+}
+
+// chartMetadata returns the metadata of chart as templates see it in .Chart,
+// where the aliases and import-values of the dependencies are resolved.
+// Disabled dependencies are removed from it at render time.
+// cf. chartutil.processDependencyEnabled and chartutil.processImportValues
+func chartMetadata(chart *helmchart.Chart, name string) *helmchart.Metadata {
+	metadata := *chart.Metadata
+	metadata.Name = name
+	if chart.Metadata.Dependencies == nil {
+		return &metadata
+	}
+
+	metadata.Dependencies = []*helmchart.Dependency{}
+	for _, req := range chart.Metadata.Dependencies {
+		if req == nil {
+			continue
+		}
+		dep := *req
+		dep.Name = dependencyName(req)
+		dep.ImportValues = nil
+		for _, iv := range convertImportValues(req) {
+			dep.ImportValues = append(dep.ImportValues, map[string]string{
+				"child":  iv.Child,
+				"parent": iv.Parent,
+			})
+		}
+		metadata.Dependencies = append(metadata.Dependencies, &dep)
+	}
+	return &metadata
+}
+
+// isDependencyOf returns true if chart satisfies dep in Chart.yaml.
+func isDependencyOf(chart *helmchart.Chart, dep *helmchart.Dependency) bool {
+	return chart.Name() == dep.Name &&
+		chartutil.IsCompatibleRange(dep.Version, chart.Metadata.Version)
+}
+
+// isLibraryChart returns true if chart is a library chart.
+func isLibraryChart(chart *helmchart.Chart) bool {
+	return strings.EqualFold(chart.Metadata.Type, "library")
+}
+
+// dependencyName returns the name of the subchart instance for dep.
+func dependencyName(dep *helmchart.Dependency) string {
+	if dep.Alias != "" {
+		return dep.Alias
+	}
+	return dep.Name
+}
+
+// convertImportValues normalizes `import-values` of dep into the form of
+// child-parent mappings. cf. chartutil.processImportValues
+func convertImportValues(dep *helmchart.Dependency) []ImportValue {
+	importValues := []ImportValue{}
+	for _, iv := range dep.ImportValues {
+		switch iv := iv.(type) {
+		case map[string]any:
+			child, _ := iv["child"].(string)
+			parent, _ := iv["parent"].(string)
+			importValues = append(importValues, ImportValue{
+				SubChart: dependencyName(dep),
+				Child:    child,
+				Parent:   parent,
+			})
+		case string:
+			importValues = append(importValues, ImportValue{
+				SubChart: dependencyName(dep),
+				Child:    "exports." + iv,
+				Parent:   ".",
+			})
+		}
+	}
+	return importValues
+}
+
+// splitConditions splits a dependency's condition into paths in the same way
+// as chartutil.ProcessDependencies does. The first path that resolves to a
+// boolean value decides whether the dependency is enabled.
+func splitConditions(condition string) []string {
+	conditions := []string{}
+	for _, c := range strings.Split(strings.TrimSpace(condition), ",") {
+		if len(c) > 0 {
+			conditions = append(conditions, c)
+		}
+	}
+	return conditions
+}
+
+// Load loads the chart at chartPath, which is either a directory or a packaged
+// chart (.tgz).
+func Load(chartPath string) (*RootChart, error) {
+	return LoadWithOptions(chartPath, &LoadOptions{})
+}
+
+// LoadWithOptions is the same as Load, but the dependencies missing from
+// charts/ are resolved locally as specified by opts.
+func LoadWithOptions(chartPath string, opts *LoadOptions) (*RootChart, error) {
+	chart, err := LoadHelmChart(chartPath, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	tmpls := template.New(chartDir)
-	tmpls.Funcs(funcMap())
-	rootChart, err := loadChartsRecursively(tmpls, chart)
+	tmpls := template.New(chartPath)
+	tmpls.Funcs(funcMap(opts.Functions))
+	rootChart, err := loadChartsRecursively(tmpls, chart, chart.Name(), chart.ChartFullPath())
 	if err != nil {
 		return nil, err
 	}
@@ -119,7 +319,25 @@ func Load(chartDir string) (*RootChart, error) {
 	}, nil
 }
 
-func funcMap() template.FuncMap {
+// LoadHelmChart loads the chart with Helm's loader and resolves the missing
+// dependencies in the same way as LoadWithOptions, so that the chart can be
+// rendered by Helm itself.
+func LoadHelmChart(chartPath string, opts *LoadOptions) (*helmchart.Chart, error) {
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resolveDependencies(chart, chartPath, opts); err != nil {
+		return nil, err
+	}
+
+	return chart, nil
+}
+
+// funcMap returns the functions that the templates are parsed with. Only their
+// names matter because the templates are compiled instead of executed.
+func funcMap(functions []string) template.FuncMap {
 	f := sprig.TxtFuncMap()
 	delete(f, "env")
 	delete(f, "expandenv")
@@ -142,5 +360,9 @@ func funcMap() template.FuncMap {
 
 	maps.Copy(f, extra)
 
+	for _, name := range functions {
+		f[name] = func(...any) any { return "not implemented" }
+	}
+
 	return f
 }
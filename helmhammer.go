@@ -0,0 +1,363 @@
+// Package helmhammer compiles Helm charts into Jsonnet and renders them
+// without Helm's template engine.
+//
+//	artifact, err := helmhammer.Compile("path/to/chart", helmhammer.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	manifests, err := artifact.Render(ctx, helmhammer.RenderOptions{
+//		Values:    map[string]any{"replicaCount": 3},
+//		Namespace: "prod",
+//	})
+package helmhammer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/ushitora-anqou/helmhammer/compiler"
+	"github.com/ushitora-anqou/helmhammer/helm"
+	"github.com/ushitora-anqou/helmhammer/jsonnet"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sjson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// DefaultKubeVersion is the Kubernetes version used when Options.KubeVersion
+// is empty.
+const DefaultKubeVersion = "1.32.0"
+
+// Order is the order of the rendered manifests, which is given to the
+// compiled Jsonnet as order.
+type Order string
+
+const (
+	// OrderTemplates outputs the manifests in the order of the templates,
+	// i.e., the sorted paths of the templates, which is the default.
+	OrderTemplates Order = ""
+	// OrderInstall sorts the manifests by kind in the order that Helm
+	// installs them, e.g. Namespaces first.
+	OrderInstall Order = "install"
+	// OrderUninstall sorts the manifests by kind in the order that Helm
+	// uninstalls them.
+	OrderUninstall Order = "uninstall"
+)
+
+// Options are the options of Compile.
+type Options struct {
+	// RepositoryDirs are the local directories to resolve missing
+	// dependencies from. cf. helm.LoadOptions
+	RepositoryDirs []string
+	// KubeVersion is .Capabilities.KubeVersion. Empty means
+	// DefaultKubeVersion.
+	KubeVersion string
+	// APIVersions are added to .Capabilities.APIVersions.
+	APIVersions []string
+	// Capabilities are merged into .Capabilities with JSON merge patch, e.g.
+	// {"HelmVersion": {"Version": "v3.17.0"}}.
+	Capabilities map[string]any
+	// Functions are the functions defined by the user, which map names to
+	// Jsonnet functions that take the list of the arguments, e.g.
+	// `function(args) {}` for lookup. They override the predefined functions.
+	// cf. compiler.Options.Functions
+	Functions map[string]string
+	// Order is the order of the rendered manifests.
+	Order Order
+	// Compact makes Artifact.Jsonnet and Artifact.Files print the Jsonnet
+	// without line breaks instead of formatting it like jsonnetfmt.
+	Compact bool
+}
+
+func (o *Options) validate() error {
+	switch o.Order {
+	case OrderTemplates, OrderInstall, OrderUninstall:
+		return nil
+	}
+	return fmt.Errorf("invalid order: %s", o.Order)
+}
+
+// RenderOptions are the options of Artifact.Render, which correspond to the
+// ones of helm template.
+type RenderOptions struct {
+	// Values are the values given by the user, which are coalesced with the
+	// values of the chart. cf. values.Options.MergeValues
+	Values map[string]any
+	// Namespace is the namespace of the release. Empty means "default".
+	Namespace string
+	// ReleaseName is the name of the release. Empty means the chart name.
+	ReleaseName string
+	IsUpgrade   bool
+	// Revision is .Release.Revision. Zero means 1.
+	Revision    int
+	IncludeCrds bool
+	SkipHooks   bool
+	// SkipTests omits the hooks of the test event. As in Helm, a template
+	// under templates/tests/ is a test only if it has the annotation.
+	SkipTests bool
+}
+
+// Artifact is a chart compiled into Jsonnet. It can be rendered many times
+// and concurrently.
+type Artifact struct {
+	chart *helm.RootChart
+	expr  *jsonnet.Expr
+	opts  Options
+	// source is the compiled Jsonnet that Render evaluates.
+	source string
+	// vms are the VMs that have evaluated source. A VM caches the values of
+	// the imported files, so the runtime and the chart are evaluated once
+	// for each VM, but it can't be used concurrently.
+	vms sync.Pool
+}
+
+// Compile loads the chart at chartPath, which is either a directory or a
+// packaged chart (.tgz), and compiles it into Jsonnet.
+func Compile(chartPath string, opts Options) (*Artifact, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	chart, err := helm.LoadWithOptions(chartPath, &helm.LoadOptions{
+		RepositoryDirs: opts.RepositoryDirs,
+		Functions:      slices.Sorted(maps.Keys(opts.Functions)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+
+	return CompileChart(chart, opts)
+}
+
+// CompileChart is the same as Compile, but compiles the chart that is already
+// loaded. The chart must be loaded with the names of opts.Functions in
+// helm.LoadOptions.Functions, and opts.RepositoryDirs is ignored.
+func CompileChart(chart *helm.RootChart, opts Options) (*Artifact, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	expr, err := compiler.CompileChartWithOptions(chart, &compiler.Options{Functions: opts.Functions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile chart: %w", err)
+	}
+
+	a := &Artifact{chart: chart, expr: expr, opts: opts, source: expr.StringWithPrologue()}
+	a.vms.New = a.newVM
+	return a, nil
+}
+
+// Jsonnet returns the compiled Jsonnet, which is the same as the output of
+// the compile subcommand. It evaluates to a function whose parameters are
+// the options to render the chart.
+func (a *Artifact) Jsonnet() string {
+	if a.opts.Compact {
+		return a.expr.StringWithPrologue()
+	}
+	return a.expr.PrettyWithPrologue()
+}
+
+// Files returns the compiled Jsonnet split into files, which is the same as
+// the output of the compile subcommand with --output-dir. They map paths
+// relative to the output directory to their contents, and main.jsonnet
+// evaluates to the same function as Jsonnet. cf. compiler.CompileChartFiles
+//
+// The chart is compiled again, so Files should be called only when the files
+// are written out.
+func (a *Artifact) Files() (map[string]string, error) {
+	exprs, err := compiler.CompileChartFiles(a.chart, &compiler.Options{Functions: a.opts.Functions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile chart: %w", err)
+	}
+	files := make(map[string]string, len(exprs))
+	for path, expr := range exprs {
+		if a.opts.Compact {
+			files[path] = expr.String() + "\n"
+		} else {
+			files[path] = expr.Pretty() + "\n"
+		}
+	}
+	return files, nil
+}
+
+// RenderError is an error that occurs while rendering a chart, e.g. by fail
+// or required in the templates.
+type RenderError struct {
+	// Message is the message of the error, e.g. "fail: foo is required".
+	Message string
+	// Locations are the locations in the templates where the error occurs,
+	// e.g. "mychart/templates/a.yaml:3:5", from the innermost one. A location
+	// in a named template is followed by the one that includes it.
+	Locations []string
+	// Err is the error of go-jsonnet, whose stack trace points into the
+	// compiled Jsonnet.
+	Err error
+}
+
+func (e *RenderError) Error() string {
+	var b strings.Builder
+	b.WriteString("failed to render chart: " + e.Message)
+	for _, location := range e.Locations {
+		b.WriteString("\n\tat " + location)
+	}
+	return b.String()
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// errorRecorder records the error that go-jsonnet formats, since the VM
+// returns only the formatted message.
+type errorRecorder struct {
+	gojsonnet.ErrorFormatter
+	err error
+}
+
+func (r *errorRecorder) Format(err error) string {
+	r.err = err
+	return r.ErrorFormatter.Format(err)
+}
+
+// newRenderError translates the stack trace of rawErr, which occurs while
+// evaluating source imported as renderFileName, into the locations in the
+// templates. err is the error returned by the VM.
+func newRenderError(source string, err, rawErr error) error {
+	var runtimeErr gojsonnet.RuntimeError
+	if !errors.As(rawErr, &runtimeErr) {
+		return &RenderError{Message: err.Error(), Err: err}
+	}
+
+	sourceMap := jsonnet.NewSourceMap(source)
+	locations := []string{}
+	// The stack trace begins with the outermost frame.
+	for _, frame := range slices.Backward(runtimeErr.StackTrace) {
+		if frame.Loc.FileName != renderFileName {
+			continue
+		}
+		location, ok := sourceMap.Locate(frame.Loc.Begin.Line, frame.Loc.Begin.Column)
+		if ok && (len(locations) == 0 || locations[len(locations)-1] != location) {
+			locations = append(locations, location)
+		}
+	}
+	return &RenderError{Message: runtimeErr.Msg, Locations: locations, Err: err}
+}
+
+// renderFileName is the name of the compiled Jsonnet imported by Render.
+const renderFileName = "chart.jsonnet"
+
+// renderVM is a VM to render the chart, whose errors are recorded.
+type renderVM struct {
+	vm       *gojsonnet.VM
+	recorder *errorRecorder
+}
+
+// newVM makes a VM that imports the compiled Jsonnet and is given the options
+// of Compile as the top-level arguments.
+func (a *Artifact) newVM() any {
+	vm := gojsonnet.MakeVM()
+	// The compiled templates recurse deeply.
+	vm.MaxStack = 2000
+	recorder := &errorRecorder{ErrorFormatter: vm.ErrorFormatter}
+	vm.ErrorFormatter = recorder
+	vm.Importer(&gojsonnet.MemoryImporter{Data: map[string]gojsonnet.Contents{
+		renderFileName: gojsonnet.MakeContents(a.source),
+	}})
+
+	kubeVersion := a.opts.KubeVersion
+	if kubeVersion == "" {
+		kubeVersion = DefaultKubeVersion
+	}
+	vm.TLAVar("kubeVersion", kubeVersion)
+	if a.opts.APIVersions != nil {
+		vm.TLACode("apiVersions", jsonnet.ConvertIntoJsonnet(a.opts.APIVersions).String())
+	}
+	if a.opts.Capabilities != nil {
+		vm.TLACode("capabilities", jsonnet.ConvertIntoJsonnet(a.opts.Capabilities).String())
+	}
+	if a.opts.Order != OrderTemplates {
+		vm.TLAVar("order", string(a.opts.Order))
+	}
+	return &renderVM{vm: vm, recorder: recorder}
+}
+
+// setRenderOptions gives opts to vm as the top-level arguments. They replace
+// the ones of the previous rendering since the same ones are always given.
+func (a *Artifact) setRenderOptions(vm *gojsonnet.VM, opts *RenderOptions) {
+	values := opts.Values
+	if values == nil {
+		values = map[string]any{}
+	}
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	releaseName := opts.ReleaseName
+	if releaseName == "" {
+		releaseName = a.chart.Name
+	}
+	revision := opts.Revision
+	if revision == 0 {
+		revision = 1
+	}
+
+	vm.TLACode("values", jsonnet.ConvertIntoJsonnet(values).String())
+	vm.TLAVar("namespace", namespace)
+	vm.TLAVar("releaseName", releaseName)
+	vm.TLACode("isUpgrade", strconv.FormatBool(opts.IsUpgrade))
+	vm.TLACode("revision", strconv.Itoa(revision))
+	vm.TLACode("includeCrds", strconv.FormatBool(opts.IncludeCrds))
+	vm.TLACode("includeHooks", strconv.FormatBool(!opts.SkipHooks))
+	vm.TLACode("includeTests", strconv.FormatBool(!opts.SkipTests))
+}
+
+// Render renders the manifests including hooks and tests as helm template
+// does. Errors in the templates are returned as *RenderError. Jsonnet can't be
+// interrupted, so the evaluation continues in the background until it
+// finishes even if ctx is done.
+func (a *Artifact) Render(ctx context.Context, opts RenderOptions) ([]unstructured.Unstructured, error) {
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		vm := a.vms.Get().(*renderVM)
+		defer a.vms.Put(vm)
+		a.setRenderOptions(vm.vm, &opts)
+		vm.recorder.err = nil
+		output, err := vm.vm.EvaluateAnonymousSnippet("main.jsonnet", fmt.Sprintf("import '%s'", renderFileName))
+		if err != nil {
+			err = newRenderError(a.source, err, vm.recorder.err)
+		}
+		done <- result{output: output, err: err}
+	}()
+
+	var output string
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		output = res.output
+	}
+
+	var objects []map[string]any
+	// k8s.io/apimachinery's json decodes integers into int64 as Kubernetes
+	// clients expect.
+	if err := k8sjson.Unmarshal([]byte(output), &objects); err != nil {
+		return nil, fmt.Errorf("failed to parse the rendered manifests: %w", err)
+	}
+	manifests := make([]unstructured.Unstructured, 0, len(objects))
+	for _, object := range objects {
+		manifests = append(manifests, unstructured.Unstructured{Object: object})
+	}
+	return manifests, nil
+}
@@ -0,0 +1,204 @@
+package helmhammer_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushitora-anqou/helmhammer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func names(manifests []unstructured.Unstructured) []string {
+	result := []string{}
+	for _, manifest := range manifests {
+		result = append(result, manifest.GetKind()+"/"+manifest.GetName())
+	}
+	return result
+}
+
+func TestCompileAndRender(t *testing.T) {
+	artifact, err := helmhammer.Compile("compiler/testdata/skeleton", helmhammer.Options{})
+	require.NoError(t, err)
+	assert.Contains(t, artifact.Jsonnet(), "chartMain(")
+	compact, err := helmhammer.Compile("compiler/testdata/skeleton", helmhammer.Options{Compact: true})
+	require.NoError(t, err)
+	assert.Less(t, len(compact.Jsonnet()), len(artifact.Jsonnet()))
+
+	manifests, err := artifact.Render(context.Background(), helmhammer.RenderOptions{
+		Values:      map[string]any{"replicaCount": 3},
+		ReleaseName: "foo",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Deployment/foo-skeleton",
+		"Service/foo-skeleton",
+		"ServiceAccount/foo-skeleton",
+		"Pod/foo-skeleton-test-connection",
+	}, names(manifests))
+	replicas, found, err := unstructured.NestedInt64(manifests[0].Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(3), replicas)
+
+	manifests, err = artifact.Render(context.Background(), helmhammer.RenderOptions{SkipTests: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Deployment/skeleton",
+		"Service/skeleton",
+		"ServiceAccount/skeleton",
+	}, names(manifests))
+}
+
+func TestCompileWithOptions(t *testing.T) {
+	artifact, err := helmhammer.Compile("testdata/functions", helmhammer.Options{
+		Capabilities: map[string]any{"HelmVersion": map[string]any{"Version": "v3.0.0"}},
+		Functions: map[string]string{
+			"double": "function(args) args[0] * 2",
+			"lookup": `function(args) if args[3] == "password" then { data: { password: "secret" } } else {}`,
+		},
+		Order: helmhammer.OrderInstall,
+	})
+	require.NoError(t, err)
+
+	manifests, err := artifact.Render(context.Background(), helmhammer.RenderOptions{Namespace: "ns"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Namespace/ns", "ConfigMap/functions"}, names(manifests))
+	data, _, err := unstructured.NestedStringMap(manifests[1].Object, "data")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"doubled":     "4",
+		"password":    "secret",
+		"helmVersion": "v3.0.0",
+	}, data)
+
+	_, err = helmhammer.Compile("testdata/functions", helmhammer.Options{})
+	assert.ErrorContains(t, err, `function "double" not defined`)
+
+	_, err = helmhammer.Compile("testdata/functions", helmhammer.Options{Order: "unknown"})
+	assert.ErrorContains(t, err, "invalid order: unknown")
+}
+
+func TestFiles(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		artifact, err := helmhammer.Compile("testdata/functions", helmhammer.Options{
+			Functions: map[string]string{
+				"double": "function(args) args[0] * 2",
+				"lookup": "function(args) {}",
+			},
+			Compact: compact,
+		})
+		require.NoError(t, err)
+		files, err := artifact.Files()
+		require.NoError(t, err)
+		assert.Contains(t, files, "helmhammer.libsonnet")
+		assert.Contains(t, files, "functions/templates/configmap.yaml.libsonnet")
+		dir := t.TempDir()
+		for name, source := range files {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.NoError(t, os.WriteFile(path, []byte(source), 0o644))
+		}
+
+		// The files evaluate to the same as Jsonnet.
+		vm := gojsonnet.MakeVM()
+		vm.MaxStack = 2000
+		expected, err := vm.EvaluateAnonymousSnippet("main.jsonnet", artifact.Jsonnet())
+		require.NoError(t, err)
+		got, err := vm.EvaluateFile(filepath.Join(dir, "main.jsonnet"))
+		require.NoError(t, err)
+		assert.Equal(t, expected, got)
+	}
+}
+
+func TestRenderConcurrently(t *testing.T) {
+	artifact, err := helmhammer.Compile("compiler/testdata/skeleton", helmhammer.Options{})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			releaseName := fmt.Sprintf("release%d", i)
+			manifests, err := artifact.Render(context.Background(), helmhammer.RenderOptions{
+				ReleaseName: releaseName,
+				SkipTests:   i%2 == 0,
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			expected := []string{
+				"Deployment/" + releaseName + "-skeleton",
+				"Service/" + releaseName + "-skeleton",
+				"ServiceAccount/" + releaseName + "-skeleton",
+			}
+			if i%2 != 0 {
+				expected = append(expected, "Pod/"+releaseName+"-skeleton-test-connection")
+			}
+			assert.Equal(t, expected, names(manifests))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenderCanceled(t *testing.T) {
+	artifact, err := helmhammer.Compile("compiler/testdata/skeleton", helmhammer.Options{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = artifact.Render(ctx, helmhammer.RenderOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRenderError(t *testing.T) {
+	artifact, err := helmhammer.Compile("testdata/failing", helmhammer.Options{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		values            map[string]any
+		expectedMessage   string
+		expectedLocations []string
+	}{
+		{
+			name:            "required in a named template",
+			expectedMessage: "password is required",
+			expectedLocations: []string{
+				"failing/templates/_helpers.tpl:2:4",
+				"failing/templates/secret.yaml:6:47",
+			},
+		},
+		{
+			name:              "fail",
+			values:            map[string]any{"password": "x", "legacy": true},
+			expectedMessage:   "fail: legacy is not supported",
+			expectedLocations: []string{"failing/templates/secret.yaml:8:7"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := artifact.Render(context.Background(), helmhammer.RenderOptions{Values: tt.values})
+			var renderErr *helmhammer.RenderError
+			require.ErrorAs(t, err, &renderErr)
+			assert.Equal(t, tt.expectedMessage, renderErr.Message)
+			assert.Equal(t, tt.expectedLocations, renderErr.Locations)
+			assert.ErrorContains(t, renderErr.Err, "RUNTIME ERROR")
+		})
+	}
+
+	_, err = artifact.Render(context.Background(), helmhammer.RenderOptions{})
+	assert.EqualError(t, err, `failed to render chart: password is required
+	at failing/templates/_helpers.tpl:2:4
+	at failing/templates/secret.yaml:6:47`)
+}
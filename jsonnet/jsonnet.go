@@ -20,6 +20,7 @@ const (
 	EFunction
 	EID
 	EIf
+	EImport
 	EIndex
 	EIndexList
 	EIntLiteral
@@ -55,6 +56,26 @@ type Expr struct {
 	BinOpRHS       *Expr
 	FloatLiteral   float64
 	Raw            string
+	// Location is the location in the templates that the expression is
+	// compiled from, e.g. "mychart/templates/a.yaml:3:5". It's printed as
+	// markers around the expression for SourceMap.
+	Location string
+}
+
+// Located returns a copy of e that has location unless e already has one, so
+// that the innermost location is kept. Expressions that can't fail, such as
+// literals and variables, are returned as they are to keep the output small.
+func Located(e *Expr, location string) *Expr {
+	if e == nil || e.Location != "" {
+		return e
+	}
+	switch e.Kind {
+	case EFalse, EFloatLiteral, EID, EIndex, EIndexList, EIntLiteral, ENull, EStringLiteral, ETrue:
+		return e
+	}
+	located := *e
+	located.Location = location
+	return &located
 }
 
 func (e *Expr) precedence() int {
@@ -94,6 +115,8 @@ func (e *Expr) precedence() int {
 		fallthrough
 	case EIf:
 		fallthrough
+	case EImport:
+		fallthrough
 	case ELocal:
 		return -3
 	}
@@ -113,6 +136,13 @@ func wrapParen(b *strings.Builder, base, e *Expr) {
 }
 
 func (e *Expr) String() string {
+	if e.Location == "" {
+		return e.stringWithoutLocation()
+	}
+	return locationMarker(e.Location) + e.stringWithoutLocation() + locationMarker("")
+}
+
+func (e *Expr) stringWithoutLocation() string {
 	switch e.Kind {
 	case EAdd:
 		b := strings.Builder{}
@@ -170,6 +200,9 @@ func (e *Expr) String() string {
 		wrapParen(&b, e, e.IfElse)
 		return b.String()
 
+	case EImport:
+		return fmt.Sprintf("import \"%s\"", escapeString(e.StringLiteral, false, true))
+
 	case EIndex:
 		b := strings.Builder{}
 		wrapParen(&b, e, e.BinOpLHS)
@@ -284,12 +317,7 @@ type MapEntry struct {
 var prologue string
 
 func (e *Expr) StringWithPrologue() string {
-	keyword := "// DON'T USE BELOW\n"
-	index := strings.Index(prologue, keyword)
-	if index == -1 {
-		panic("invalid prologue")
-	}
-	return prologue[0:index] + e.String()
+	return prologueHead() + e.String()
 }
 
 var emptyString = &Expr{
@@ -398,13 +426,13 @@ func ConvertIntoJsonnet(data any) *Expr {
 		}
 		return &Expr{Kind: kind}
 
-	case reflect.Int:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return &Expr{Kind: EIntLiteral, IntLiteral: int(v.Int())}
 
-	case reflect.Uint16:
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		return &Expr{Kind: EIntLiteral, IntLiteral: int(v.Uint())}
 
-	case reflect.Float64:
+	case reflect.Float32, reflect.Float64:
 		return &Expr{Kind: EFloatLiteral, FloatLiteral: v.Float()}
 
 	case reflect.String:
@@ -414,21 +442,12 @@ func ConvertIntoJsonnet(data any) *Expr {
 		if v.IsNil() {
 			return &Expr{Kind: ENull}
 		}
-		exprMap := []*MapEntry{}
+		exprMap := map[string]*Expr{}
 		iter := v.MapRange()
 		for iter.Next() {
-			exprMap = append(exprMap, &MapEntry{
-				K: &Expr{
-					Kind:          EStringLiteral,
-					StringLiteral: iter.Key().Interface().(string),
-				},
-				V: ConvertIntoJsonnet(iter.Value().Interface()),
-			})
-		}
-		return &Expr{
-			Kind: EMap,
-			Map:  exprMap,
+			exprMap[iter.Key().Interface().(string)] = ConvertIntoJsonnet(iter.Value().Interface())
 		}
+		return Map(exprMap)
 
 	case reflect.Struct:
 		exprMap := []*MapEntry{}
@@ -498,8 +517,7 @@ func IdentityFunction() *Expr {
 
 func escapeString(s string, escapeSingleQuote bool, escapeDoubleQuote bool) string {
 	var b strings.Builder
-	buf := []byte(s)
-	for _, ch := range buf {
+	for _, ch := range []byte(s) {
 		switch ch {
 		case '\\':
 			b.WriteString("\\\\")
@@ -529,21 +547,20 @@ func escapeString(s string, escapeSingleQuote bool, escapeDoubleQuote bool) stri
 }
 
 func CallChartMetadata(
-	name, version, appVersion, templateBasePath, condition string,
+	name string,
+	chart *Expr,
+	templateBasePath string,
+	conditions, tags []string,
 	renderedKeys []string,
+	notesKey string,
 	defaultValues *Expr,
+	importValues []*Expr,
+	schema *Expr,
 	crds [][]byte,
 	compiledFiles map[string]*Expr,
 	compiledSubChartMetadata []*Expr,
+	subChartOrder []string,
 ) *Expr {
-	exprKeys := []*Expr{}
-	for _, key := range renderedKeys {
-		exprKeys = append(exprKeys, &Expr{
-			Kind:          EStringLiteral,
-			StringLiteral: key,
-		})
-	}
-
 	crdsList := []*Expr{}
 	for _, crd := range crds {
 		crdsList = append(crdsList, &Expr{
@@ -552,24 +569,44 @@ func CallChartMetadata(
 		})
 	}
 
+	notesKeyExpr := &Expr{Kind: ENull}
+	if notesKey != "" {
+		notesKeyExpr = &Expr{Kind: EStringLiteral, StringLiteral: notesKey}
+	}
+
 	return &Expr{
 		Kind:     ECall,
 		CallFunc: Index("chartMetadata"),
 		CallArgs: []*Expr{
 			{Kind: EStringLiteral, StringLiteral: name},
-			{Kind: EStringLiteral, StringLiteral: version},
-			{Kind: EStringLiteral, StringLiteral: appVersion},
+			chart,
 			{Kind: EStringLiteral, StringLiteral: templateBasePath},
-			{Kind: EStringLiteral, StringLiteral: condition},
-			{Kind: EList, List: exprKeys},
+			{Kind: EList, List: stringList(conditions)},
+			{Kind: EList, List: stringList(tags)},
+			{Kind: EList, List: stringList(renderedKeys)},
+			notesKeyExpr,
 			defaultValues,
+			{Kind: EList, List: importValues},
+			schema,
 			{Kind: EList, List: crdsList},
 			Map(compiledFiles),
 			{Kind: EList, List: compiledSubChartMetadata},
+			{Kind: EList, List: stringList(subChartOrder)},
 		},
 	}
 }
 
+func stringList(src []string) []*Expr {
+	list := []*Expr{}
+	for _, s := range src {
+		list = append(list, &Expr{
+			Kind:          EStringLiteral,
+			StringLiteral: s,
+		})
+	}
+	return list
+}
+
 func CallChartMain(capabilities, rootChart, initialHeap, body *Expr) *Expr {
 	return &Expr{
 		Kind:     ECall,
@@ -1,12 +1,29 @@
 package jsonnet_test
 
 import (
+	"strings"
 	"testing"
 
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/formatter"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/ushitora-anqou/helmhammer/jsonnet"
 )
 
+// call, list and str build the expressions in the tests.
+func call(name string, args ...*jsonnet.Expr) *jsonnet.Expr {
+	return &jsonnet.Expr{Kind: jsonnet.ECall, CallFunc: jsonnet.Index(name), CallArgs: args}
+}
+
+func list(items ...*jsonnet.Expr) *jsonnet.Expr {
+	return &jsonnet.Expr{Kind: jsonnet.EList, List: items}
+}
+
+func str(s string) *jsonnet.Expr {
+	return &jsonnet.Expr{Kind: jsonnet.EStringLiteral, StringLiteral: s}
+}
+
 func TestConvertIntoJsonnet(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -14,6 +31,10 @@ func TestConvertIntoJsonnet(t *testing.T) {
 		output *jsonnet.Expr
 	}{
 		{"int", 1000, &jsonnet.Expr{Kind: jsonnet.EIntLiteral, IntLiteral: 1000}},
+		{"int64", int64(1000), &jsonnet.Expr{Kind: jsonnet.EIntLiteral, IntLiteral: 1000}},
+		{"uint16", uint16(1000), &jsonnet.Expr{Kind: jsonnet.EIntLiteral, IntLiteral: 1000}},
+		{"float64", 1.5, &jsonnet.Expr{Kind: jsonnet.EFloatLiteral, FloatLiteral: 1.5}},
+		{"nil", nil, &jsonnet.Expr{Kind: jsonnet.ENull}},
 	}
 
 	for _, tt := range tests {
@@ -23,3 +44,133 @@ func TestConvertIntoJsonnet(t *testing.T) {
 		})
 	}
 }
+
+func TestSourceMap(t *testing.T) {
+	expr := jsonnet.Located(call("f",
+		jsonnet.Located(call("g", str("/*@a*/")), "b.yaml:2:3"),
+		call("h"),
+	), "a.yaml:1:2")
+	// Literals can't fail, so they have no locations.
+	assert.Same(t, expr.CallArgs[0].CallArgs[0], jsonnet.Located(expr.CallArgs[0].CallArgs[0], "c.yaml:1:1"))
+	// The innermost location is kept.
+	assert.Same(t, expr, jsonnet.Located(expr, "c.yaml:1:1"))
+
+	// Markers in strings and comments are ignored.
+	source := "local x = '/*@c.yaml:1:1*/'; // /*@c.yaml:1:1*/\n" + expr.String()
+	assert.Equal(t, `local x = '/*@c.yaml:1:1*/'; // /*@c.yaml:1:1*/
+/*@a.yaml:1:2*/f(/*@b.yaml:2:3*/g("/*@a*/")/*@*/, h())/*@*/`, source)
+
+	sourceMap := jsonnet.NewSourceMap(source)
+	for _, tt := range []struct {
+		line, column int
+		expected     string
+	}{
+		{2, 16, "a.yaml:1:2"},
+		{2, 33, "b.yaml:2:3"},
+		{2, 40, "b.yaml:2:3"},
+		{2, 52, "a.yaml:1:2"},
+		{1, 1, ""},
+		{3, 1, ""},
+	} {
+		location, ok := sourceMap.Locate(tt.line, tt.column)
+		assert.Equal(t, tt.expected != "", ok, "%d:%d", tt.line, tt.column)
+		assert.Equal(t, tt.expected, location, "%d:%d", tt.line, tt.column)
+	}
+}
+
+func TestPretty(t *testing.T) {
+	long := strings.Repeat("x", 90)
+	expr := &jsonnet.Expr{
+		Kind: jsonnet.ELocal,
+		LocalBinds: []*jsonnet.LocalBind{
+			{Name: "a", Body: jsonnet.Map(map[string]*jsonnet.Expr{
+				"b":   str("it's"),
+				"c-d": &jsonnet.Expr{Kind: jsonnet.EList, List: []*jsonnet.Expr{str(long), jsonnet.Index("a", "e")}},
+			})},
+			{Name: "f", Body: &jsonnet.Expr{
+				Kind:           jsonnet.EFunction,
+				FunctionParams: []string{"x"},
+				FunctionBody: &jsonnet.Expr{
+					Kind:   jsonnet.EIf,
+					IfCond: jsonnet.Located(call("g", jsonnet.Index("x")), "a.yaml:1:2"),
+					IfThen: jsonnet.AddMap(jsonnet.Index("x"), []*jsonnet.MapEntry{
+						{K: str("y"), V: str(long)},
+					}),
+					IfElse: &jsonnet.Expr{Kind: jsonnet.ENull},
+				},
+			}},
+		},
+		LocalBody: call("f", jsonnet.Index("a")),
+	}
+	assert.Equal(t, `local
+  a = {
+    b: "it's",
+    'c-d': [
+      'xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx',
+      a.e,
+    ],
+  },
+  f = function(x)
+    if /*@a.yaml:1:2*/ g(x) /*@*/ then
+      x {
+        y: 'xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx',
+      }
+    else
+      null;
+f(a)`, expr.Pretty())
+
+	formatted, err := formatter.Format("file.jsonnet", expr.Pretty(), formatter.DefaultOptions())
+	require.NoError(t, err)
+	assert.Equal(t, expr.Pretty()+"\n", formatted)
+
+	sourceMap := jsonnet.NewSourceMap(expr.Pretty())
+	location, ok := sourceMap.Locate(10, 25)
+	assert.True(t, ok)
+	assert.Equal(t, "a.yaml:1:2", location)
+}
+
+func TestOptimize(t *testing.T) {
+	pure := map[string]bool{"fail": true, "printf": true, "quote": true}
+	expr := &jsonnet.Expr{
+		Kind:           jsonnet.EFunction,
+		FunctionParams: []string{"h", "y"},
+		FunctionBody: &jsonnet.Expr{
+			Kind: jsonnet.ELocal,
+			LocalBinds: []*jsonnet.LocalBind{
+				{Name: "v", Body: jsonnet.Located(call("quote", list(str("a"), &jsonnet.Expr{Kind: jsonnet.EIntLiteral, IntLiteral: 1})), "a.yaml:1:2")},
+				{Name: "w", Body: call("fail", list(str("unused")))},
+				{Name: "u", Body: call("printf", list(str("%s"), jsonnet.Index("y")))},
+			},
+			LocalBody: jsonnet.CallJoin(jsonnet.Index("h"), []*jsonnet.Expr{
+				str("a"),
+				&jsonnet.Expr{Kind: jsonnet.EIntLiteral, IntLiteral: 1},
+				jsonnet.Index("v"),
+				jsonnet.EmptyString(),
+				&jsonnet.Expr{Kind: jsonnet.ETrue},
+				&jsonnet.Expr{Kind: jsonnet.ENull},
+				jsonnet.Index("u"),
+				str("b"),
+				call("printf", list(str("%s"), str("c"))),
+			}),
+		},
+	}
+	source := expr.String()
+	optimized := jsonnet.Optimize(expr, pure)
+	assert.Equal(t, `function(h, y) local v = "\"a\" \"1\"", u = printf(["%s", y]); `+
+		`_join(h, ["a1", v, "truenull", u, "bc"])`, optimized.String())
+	// expr is not modified.
+	assert.Equal(t, source, expr.String())
+
+	// The values are the same.
+	vm := gojsonnet.MakeVM()
+	for _, e := range []*jsonnet.Expr{expr, optimized} {
+		output, err := vm.EvaluateAnonymousSnippet("file.jsonnet",
+			(&jsonnet.Expr{Kind: jsonnet.ECall, CallFunc: e, CallArgs: []*jsonnet.Expr{jsonnet.EmptyMap(), str("z")}}).StringWithPrologue())
+		require.NoError(t, err)
+		assert.Equal(t, "\"a1\\\"a\\\" \\\"1\\\"truenullzbc\"\n", output)
+	}
+
+	// Calls that fail are left to fail at runtime.
+	failure := jsonnet.Located(call("fail", list(str("x"))), "a.yaml:1:2")
+	assert.Same(t, failure, jsonnet.Optimize(failure, pure))
+}
@@ -0,0 +1,326 @@
+package jsonnet
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	gojsonnet "github.com/google/go-jsonnet"
+)
+
+// Optimize returns e simplified without changing the value that it evaluates
+// to:
+//
+//   - The calls of the functions in pure with literal arguments, e.g.
+//     quote(["a"]), are evaluated into literals. The functions must take the
+//     list of the arguments and return a plain value.
+//   - The adjacent literals given to _join are joined into a string, and
+//     _join of a string is the string.
+//   - The local bindings that are not referred to are removed.
+//
+// e is not modified since expressions can be shared, e.g. EmptyString.
+func Optimize(e *Expr, pure map[string]bool) *Expr {
+	o := &optimizer{pure: pure, optimized: map[*Expr]*Expr{}}
+	return o.optimize(e)
+}
+
+type optimizer struct {
+	pure map[string]bool
+	// optimized maps the expressions to the optimized ones, so that shared
+	// expressions are optimized once and kept shared.
+	optimized map[*Expr]*Expr
+	// vm evaluates the calls of the pure functions. It's made when it's
+	// needed first, and it caches the imported runtime.
+	vm *gojsonnet.VM
+}
+
+func (o *optimizer) optimize(e *Expr) *Expr {
+	if optimized, ok := o.optimized[e]; ok {
+		return optimized
+	}
+	optimized := e.mapChildren(o.optimize)
+	switch optimized.Kind {
+	case ECall:
+		optimized = o.optimizeCall(optimized)
+	case ELocal:
+		optimized = removeUnusedBinds(optimized)
+	}
+	o.optimized[e] = optimized
+	return optimized
+}
+
+func (o *optimizer) optimizeCall(e *Expr) *Expr {
+	if e.CallFunc.Kind != EID || len(e.CallNamedArgs) != 0 {
+		return e
+	}
+	switch name := e.CallFunc.IDName; {
+	case name == "_join" && len(e.CallArgs) == 2 && e.CallArgs[1].Kind == EList:
+		return joinLiterals(e)
+
+	case o.pure[name] && len(e.CallArgs) == 1 && e.CallArgs[0].Kind == EList:
+		for _, arg := range e.CallArgs[0].List {
+			if !isLiteral(arg) {
+				return e
+			}
+		}
+		// The call is left as it is if it fails, e.g. fail(["message"]), so
+		// that it fails at runtime with its location.
+		if v, ok := o.evaluateLiteral(e); ok {
+			return v
+		}
+	}
+	return e
+}
+
+// joinLiterals joins the adjacent literals in the list of _join(heap, list)
+// in the same way as _strval.
+func joinLiterals(e *Expr) *Expr {
+	list := []*Expr{}
+	changed := false
+	for _, item := range e.CallArgs[1].List {
+		s, ok := literalString(item)
+		if !ok {
+			list = append(list, item)
+			continue
+		}
+		if s == "" {
+			changed = true
+			continue
+		}
+		if n := len(list); n != 0 && list[n-1].Kind == EStringLiteral {
+			list[n-1] = &Expr{Kind: EStringLiteral, StringLiteral: list[n-1].StringLiteral + s}
+			changed = true
+			continue
+		}
+		if item.Kind != EStringLiteral {
+			item = &Expr{Kind: EStringLiteral, StringLiteral: s}
+			changed = true
+		}
+		list = append(list, item)
+	}
+	switch {
+	case len(list) == 0:
+		return EmptyString()
+	case len(list) == 1 && list[0].Kind == EStringLiteral:
+		return list[0]
+	case !changed:
+		return e
+	}
+	return CallJoin(e.CallArgs[0], list)
+}
+
+// literalString returns the string of the literal e as _strval does.
+func literalString(e *Expr) (string, bool) {
+	switch e.Kind {
+	case EStringLiteral:
+		return e.StringLiteral, true
+	case EIntLiteral:
+		return strconv.Itoa(e.IntLiteral), true
+	case ETrue:
+		return "true", true
+	case EFalse:
+		return "false", true
+	case ENull:
+		return "null", true
+	}
+	return "", false
+}
+
+func isLiteral(e *Expr) bool {
+	switch e.Kind {
+	case EFalse, EFloatLiteral, EIntLiteral, ENull, EStringLiteral, ETrue:
+		return true
+	}
+	return false
+}
+
+// removeUnusedBinds removes the bindings of the local expression e that are
+// referred to neither by its body nor by the bindings that it refers to.
+func removeUnusedBinds(e *Expr) *Expr {
+	binds := map[string]*LocalBind{}
+	for _, bind := range e.LocalBinds {
+		binds[bind.Name] = bind
+	}
+	used := map[string]bool{}
+	queue := []*Expr{e.LocalBody}
+	for len(queue) != 0 {
+		refs, ok := queue[len(queue)-1].references()
+		queue = queue[:len(queue)-1]
+		if !ok {
+			return e
+		}
+		for name := range refs {
+			if bind, ok := binds[name]; ok && !used[name] {
+				used[name] = true
+				queue = append(queue, bind.Body)
+			}
+		}
+	}
+
+	if len(used) == len(e.LocalBinds) {
+		return e
+	}
+	if len(used) == 0 {
+		return Located(e.LocalBody, e.Location)
+	}
+	optimized := *e
+	optimized.LocalBinds = []*LocalBind{}
+	for _, bind := range e.LocalBinds {
+		if used[bind.Name] {
+			optimized.LocalBinds = append(optimized.LocalBinds, bind)
+		}
+	}
+	return &optimized
+}
+
+// references returns the identifiers that e may refer to. ok is false if e
+// has Jsonnet given by ERaw that is not an identifier, which may refer to
+// anything.
+func (e *Expr) references() (refs map[string]bool, ok bool) {
+	refs = map[string]bool{}
+	stack := []*Expr{e}
+	for len(stack) != 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch e.Kind {
+		case EID:
+			refs[e.IDName] = true
+		case ERaw:
+			if !identifierRegexp.MatchString(e.Raw) {
+				return nil, false
+			}
+			refs[e.Raw] = true
+		}
+		stack = append(stack, e.children()...)
+	}
+	return refs, true
+}
+
+// children returns the subexpressions of e. The keys of EMap are not included
+// since they are field names.
+func (e *Expr) children() []*Expr {
+	children := []*Expr{}
+	push := func(es ...*Expr) {
+		for _, e := range es {
+			if e != nil {
+				children = append(children, e)
+			}
+		}
+	}
+	push(e.List...)
+	push(e.IfCond, e.IfThen, e.IfElse, e.CallFunc)
+	push(e.CallArgs...)
+	for _, arg := range e.CallNamedArgs {
+		push(arg.Arg)
+	}
+	push(e.IndexListHead, e.LocalBody, e.FunctionBody, e.BinOpLHS, e.BinOpRHS)
+	for _, bind := range e.LocalBinds {
+		push(bind.Body)
+	}
+	for _, entry := range e.Map {
+		push(entry.V)
+	}
+	return children
+}
+
+// mapChildren returns a copy of e whose subexpressions are replaced by f, or
+// e itself if f returns all of them as they are. The keys of EMap are kept.
+func (e *Expr) mapChildren(f func(*Expr) *Expr) *Expr {
+	changed := false
+	mapExpr := func(e *Expr) *Expr {
+		if e == nil {
+			return nil
+		}
+		mapped := f(e)
+		changed = changed || mapped != e
+		return mapped
+	}
+	mapList := func(es []*Expr) []*Expr {
+		if es == nil {
+			return nil
+		}
+		mapped := make([]*Expr, len(es))
+		for i, e := range es {
+			mapped[i] = mapExpr(e)
+		}
+		return mapped
+	}
+
+	mapped := *e
+	mapped.List = mapList(e.List)
+	mapped.IfCond = mapExpr(e.IfCond)
+	mapped.IfThen = mapExpr(e.IfThen)
+	mapped.IfElse = mapExpr(e.IfElse)
+	mapped.CallFunc = mapExpr(e.CallFunc)
+	mapped.CallArgs = mapList(e.CallArgs)
+	if e.CallNamedArgs != nil {
+		mapped.CallNamedArgs = make([]*NamedArg, len(e.CallNamedArgs))
+		for i, arg := range e.CallNamedArgs {
+			mapped.CallNamedArgs[i] = &NamedArg{Name: arg.Name, Arg: mapExpr(arg.Arg)}
+		}
+	}
+	mapped.IndexListHead = mapExpr(e.IndexListHead)
+	if e.LocalBinds != nil {
+		mapped.LocalBinds = make([]*LocalBind, len(e.LocalBinds))
+		for i, bind := range e.LocalBinds {
+			mapped.LocalBinds[i] = &LocalBind{Name: bind.Name, Body: mapExpr(bind.Body)}
+		}
+	}
+	mapped.LocalBody = mapExpr(e.LocalBody)
+	mapped.FunctionBody = mapExpr(e.FunctionBody)
+	mapped.BinOpLHS = mapExpr(e.BinOpLHS)
+	mapped.BinOpRHS = mapExpr(e.BinOpRHS)
+	if e.Map != nil {
+		mapped.Map = make([]*MapEntry, len(e.Map))
+		for i, entry := range e.Map {
+			mapped.Map[i] = &MapEntry{K: entry.K, V: mapExpr(entry.V)}
+		}
+	}
+	if !changed {
+		return e
+	}
+	return &mapped
+}
+
+// evaluateLiteral evaluates e, which refers to nothing but the runtime, into
+// a literal. ok is false if it fails or the value is neither a string, an
+// integer, a boolean nor null.
+func (o *optimizer) evaluateLiteral(e *Expr) (literal *Expr, ok bool) {
+	if o.vm == nil {
+		o.vm = gojsonnet.MakeVM()
+		o.vm.Importer(&gojsonnet.MemoryImporter{Data: map[string]gojsonnet.Contents{
+			RuntimeFileName: gojsonnet.MakeContents(Runtime().Raw),
+		}})
+	}
+	output, err := o.vm.EvaluateAnonymousSnippet("optimize.jsonnet", ImportRuntime(e, "").String())
+	if err != nil {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, false
+	}
+	switch v := v.(type) {
+	case string:
+		return &Expr{Kind: EStringLiteral, StringLiteral: v}, true
+	case bool:
+		if v {
+			return &Expr{Kind: ETrue}, true
+		}
+		return &Expr{Kind: EFalse}, true
+	case nil:
+		return &Expr{Kind: ENull}, true
+	case json.Number:
+		// Only the integers that a double represents exactly are printed in
+		// the same way as Jsonnet.
+		n, err := v.Int64()
+		if err != nil || n < -(1<<53) || n > 1<<53 {
+			return nil, false
+		}
+		return &Expr{Kind: EIntLiteral, IntLiteral: int(n)}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,431 @@
+package jsonnet
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxLineWidth is the width that Pretty tries to fit an expression on a line
+// in. Longer expressions are broken into lines.
+const maxLineWidth = 100
+
+// printer prints Expr in the style of jsonnetfmt's default options: two-space
+// indentation, single-quoted strings, padded objects and trailing commas.
+type printer struct {
+	b         strings.Builder
+	indent    int
+	lineStart int
+	// flat is true while trying to print an expression on the rest of the
+	// line, which fails if the line gets longer than limit.
+	flat   bool
+	limit  int
+	failed bool
+	// elementStart is true at the beginning of an element on a line, where
+	// jsonnetfmt puts a comment on its own line.
+	elementStart bool
+	// endMarkers is the number of the markers of Expr.Location that end at
+	// the position. They are written with the next token since the spaces
+	// before them depend on it.
+	endMarkers int
+}
+
+// Pretty returns e as Jsonnet with a line per local binding, object field and
+// list element unless they fit on a line. jsonnetfmt leaves the output as it
+// is except for the Jsonnet given by ERaw. Use String for compact output.
+func (e *Expr) Pretty() string {
+	p := &printer{}
+	p.expr(e)
+	p.b.WriteString(p.flushEndMarkers(""))
+	return p.b.String()
+}
+
+// PrettyWithPrologue is the same as StringWithPrologue, but e is printed by
+// Pretty.
+func (e *Expr) PrettyWithPrologue() string {
+	return prologueHead() + e.Pretty() + "\n"
+}
+
+func (p *printer) write(s string) {
+	if p.failed {
+		return
+	}
+	s = p.flushEndMarkers(s) + s
+	if p.flat && (strings.Contains(s, "\n") || p.b.Len()+len(s) > p.limit) {
+		p.failed = true
+		return
+	}
+	p.b.WriteString(s)
+	p.elementStart = false
+	p.endMarkers = 0
+}
+
+// flushEndMarkers returns the pending end markers to be written before next.
+// jsonnetfmt puts a space before a comment unless ",", ")" or ";" follows
+// it, and between comments.
+func (p *printer) flushEndMarkers(next string) string {
+	if p.endMarkers == 0 {
+		return ""
+	}
+	markers := strings.Repeat(" "+locationMarker(""), p.endMarkers)
+	if strings.HasPrefix(next, ",") || strings.HasPrefix(next, ")") || strings.HasPrefix(next, ";") {
+		return markers[1:]
+	}
+	return markers
+}
+
+func (p *printer) newline() {
+	if p.flat {
+		p.failed = true
+		return
+	}
+	p.b.WriteString(p.flushEndMarkers("\n"))
+	p.endMarkers = 0
+	p.b.WriteByte('\n')
+	p.lineStart = p.b.Len()
+	p.b.WriteString(strings.Repeat(" ", p.indent))
+}
+
+// tryFlat prints by print on the rest of the line and returns true if it
+// fits. Otherwise, it prints nothing and returns false.
+func (p *printer) tryFlat(print func(q *printer)) bool {
+	if p.flat {
+		print(p)
+		return !p.failed
+	}
+	q := &printer{
+		flat:         true,
+		limit:        maxLineWidth - (p.b.Len() - p.lineStart),
+		elementStart: p.elementStart,
+		endMarkers:   p.endMarkers,
+	}
+	print(q)
+	if q.failed {
+		return false
+	}
+	p.b.WriteString(q.b.String())
+	p.elementStart = q.elementStart
+	p.endMarkers = q.endMarkers
+	return true
+}
+
+// block prints by print on new lines indented one more level.
+func (p *printer) block(print func()) {
+	p.indent += 2
+	p.newline()
+	print()
+	p.indent -= 2
+}
+
+func (p *printer) expr(e *Expr) {
+	if p.failed {
+		return
+	}
+	if e.Location == "" {
+		p.exprWithoutLocation(e)
+		return
+	}
+	if p.elementStart {
+		p.write(locationMarker(e.Location))
+		p.newline()
+		p.elementStart = true
+	} else {
+		// jsonnetfmt puts a space after a comment that precedes an expression.
+		p.write(locationMarker(e.Location) + " ")
+	}
+	p.exprWithoutLocation(e)
+	p.endMarkers++
+}
+
+// operand prints e, which is an operand of base, with parentheses if needed.
+func (p *printer) operand(base, e *Expr) {
+	if base.precedence() <= e.precedence() {
+		p.expr(e)
+		return
+	}
+	if p.tryFlat(func(q *printer) {
+		q.write("(")
+		q.expr(e)
+		q.write(")")
+	}) {
+		return
+	}
+	p.write("(")
+	p.block(func() { p.expr(e) })
+	p.newline()
+	p.write(")")
+}
+
+// value prints e after "name =" or "key:". Local expressions and
+// conditionals that don't fit on the line begin on the next line.
+func (p *printer) value(e *Expr) {
+	if p.tryFlat(func(q *printer) {
+		q.write(" ")
+		q.expr(e)
+	}) {
+		return
+	}
+	if e.Kind == ELocal || e.Kind == EIf {
+		p.block(func() { p.expr(e) })
+		return
+	}
+	p.write(" ")
+	p.expr(e)
+}
+
+// elements prints n elements enclosed by open and close, on a line if they
+// fit, or a line per element otherwise.
+func (p *printer) elements(open, close string, pad bool, n int, print func(q *printer, i int)) {
+	if n == 0 {
+		p.write(open + close)
+		return
+	}
+	if p.tryFlat(func(q *printer) {
+		q.write(open)
+		if pad {
+			q.write(" ")
+		}
+		for i := range n {
+			if i != 0 {
+				q.write(", ")
+			}
+			print(q, i)
+		}
+		if pad {
+			q.write(" ")
+		}
+		q.write(close)
+	}) {
+		return
+	}
+	p.write(open)
+	p.indent += 2
+	for i := range n {
+		p.newline()
+		p.elementStart = true
+		print(p, i)
+		p.write(",")
+	}
+	p.indent -= 2
+	p.newline()
+	p.write(close)
+}
+
+func (p *printer) exprWithoutLocation(e *Expr) {
+	switch e.Kind {
+	case EAdd:
+		p.operand(e, e.BinOpLHS)
+		// jsonnetfmt omits + before an object.
+		switch lhs := e.BinOpLHS.Kind; {
+		case e.BinOpRHS.Kind == EMap && (lhs == EID || lhs == EIndex || lhs == EIndexList):
+			p.write(" ")
+		default:
+			p.write(" + ")
+		}
+		p.operand(e, e.BinOpRHS)
+
+	case ECall:
+		p.operand(e, e.CallFunc)
+		n := len(e.CallArgs)
+		p.elements("(", ")", false, n+len(e.CallNamedArgs), func(q *printer, i int) {
+			if i < n {
+				q.expr(e.CallArgs[i])
+				return
+			}
+			q.write(e.CallNamedArgs[i-n].Name + "=")
+			q.expr(e.CallNamedArgs[i-n].Arg)
+		})
+
+	case EFunction:
+		params := "function(" + strings.Join(e.FunctionParams, ", ") + ")"
+		if p.tryFlat(func(q *printer) {
+			q.write(params + " ")
+			q.expr(e.FunctionBody)
+		}) {
+			return
+		}
+		p.write(params)
+		p.block(func() { p.expr(e.FunctionBody) })
+
+	case EIf:
+		if p.tryFlat(func(q *printer) {
+			q.write("if ")
+			q.operand(e, e.IfCond)
+			q.write(" then ")
+			q.operand(e, e.IfThen)
+			q.write(" else ")
+			q.operand(e, e.IfElse)
+		}) {
+			return
+		}
+		p.write("if ")
+		p.operand(e, e.IfCond)
+		p.write(" then")
+		p.block(func() { p.operand(e, e.IfThen) })
+		p.newline()
+		p.write("else")
+		if e.IfElse.Kind == EIf && e.IfElse.Location == "" {
+			p.write(" ")
+			p.expr(e.IfElse)
+			return
+		}
+		p.block(func() { p.operand(e, e.IfElse) })
+
+	case EImport:
+		p.write("import " + quoteString(e.StringLiteral))
+
+	case EIndex:
+		p.operand(e, e.BinOpLHS)
+		if e.BinOpRHS.Kind == EStringLiteral && isIdentifier(e.BinOpRHS.StringLiteral) {
+			p.write("." + e.BinOpRHS.StringLiteral)
+			return
+		}
+		p.write("[")
+		p.expr(e.BinOpRHS)
+		p.write("]")
+
+	case EIndexList:
+		p.operand(e, e.IndexListHead)
+		for _, key := range e.IndexListTail {
+			if isIdentifier(key) {
+				p.write("." + key)
+			} else {
+				p.write("[" + quoteString(key) + "]")
+			}
+		}
+
+	case EList:
+		p.elements("[", "]", false, len(e.List), func(q *printer, i int) {
+			q.expr(e.List[i])
+		})
+
+	case ELocal:
+		if p.flat {
+			p.failed = true
+			return
+		}
+		bind := func(bind *LocalBind) {
+			p.write(bind.Name + " =")
+			p.value(bind.Body)
+		}
+		if len(e.LocalBinds) == 1 {
+			p.write("local ")
+			bind(e.LocalBinds[0])
+			p.write(";")
+		} else {
+			p.write("local")
+			p.indent += 2
+			for i, b := range e.LocalBinds {
+				p.newline()
+				bind(b)
+				if i != len(e.LocalBinds)-1 {
+					p.write(",")
+				} else {
+					p.write(";")
+				}
+			}
+			p.indent -= 2
+		}
+		p.newline()
+		p.expr(e.LocalBody)
+
+	case EMap:
+		p.elements("{", "}", true, len(e.Map), func(q *printer, i int) {
+			switch k := e.Map[i].K; k.Kind {
+			case EID:
+				q.write(k.IDName + ":")
+			case EStringLiteral:
+				if isIdentifier(k.StringLiteral) {
+					q.write(k.StringLiteral + ":")
+				} else {
+					q.write(quoteString(k.StringLiteral) + ":")
+				}
+			default:
+				panic("unimplemented: not string key of map")
+			}
+			q.value(e.Map[i].V)
+		})
+
+	case ERaw:
+		p.write(e.Raw)
+
+	case EStringLiteral:
+		p.write(quoteString(e.StringLiteral))
+
+	default:
+		p.write(e.stringWithoutLocation())
+	}
+}
+
+var identifierRegexp = regexp.MustCompile(`^[_a-zA-Z][_a-zA-Z0-9]*$`)
+
+var keywords = map[string]bool{
+	"assert": true, "else": true, "error": true, "false": true, "for": true,
+	"function": true, "if": true, "import": true, "importstr": true,
+	"importbin": true, "in": true, "local": true, "null": true,
+	"tailstrict": true, "then": true, "self": true, "super": true,
+	"true": true,
+}
+
+// isIdentifier returns true if s can be a field name without quotes.
+func isIdentifier(s string) bool {
+	return identifierRegexp.MatchString(s) && !keywords[s]
+}
+
+// quoteString quotes s as jsonnetfmt does: single quotes unless s contains
+// them.
+func quoteString(s string) string {
+	if strings.Contains(s, "'") {
+		return `"` + stringEscape(s, false) + `"`
+	}
+	return "'" + stringEscape(s, true) + "'"
+}
+
+// stringEscape escapes s in the same way as jsonnetfmt.
+func stringEscape(s string, single bool) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '"':
+			if !single {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		case '\'':
+			if single {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || (r >= 0x7f && r <= 0x9f) {
+				b.WriteString(fmt.Sprintf(`\u%04x`, r))
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// prologueHead returns the part of the prologue that the compiled expression
+// follows.
+func prologueHead() string {
+	keyword := "// DON'T USE BELOW\n"
+	index := strings.Index(prologue, keyword)
+	if index == -1 {
+		panic("invalid prologue")
+	}
+	return prologue[0:index]
+}
@@ -0,0 +1,116 @@
+package jsonnet
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// RuntimeFileName is the name of the file of Runtime, which is imported by the
+// files of a compiled chart split into multiple files.
+const RuntimeFileName = "helmhammer.libsonnet"
+
+// runtimeName is the name of the imported runtime in the files.
+const runtimeName = "helmhammer"
+
+// runtimeFunctions returns the names of the functions defined in the
+// prologue, which the compiled expressions refer to.
+var runtimeFunctions = sync.OnceValue(func() []string {
+	node, err := gojsonnet.SnippetToAST(RuntimeFileName, prologueHead()+"null")
+	if err != nil {
+		panic("invalid prologue: " + err.Error())
+	}
+	names := []string{}
+	for {
+		local, ok := node.(*ast.Local)
+		if !ok {
+			break
+		}
+		for _, bind := range local.Binds {
+			names = append(names, string(bind.Variable))
+		}
+		node = local.Body
+	}
+	slices.Sort(names)
+	return names
+})
+
+// Runtime returns the prologue as a library, which evaluates to an object of
+// its functions. It's the same for all the charts.
+func Runtime() *Expr {
+	exports := []*MapEntry{}
+	for _, name := range runtimeFunctions() {
+		exports = append(exports, &MapEntry{K: Index(name), V: Index(name)})
+	}
+	return &Expr{
+		Kind: ERaw,
+		Raw:  prologueHead() + (&Expr{Kind: EMap, Map: exports}).Pretty(),
+	}
+}
+
+// ImportRuntime binds the functions of the runtime that e uses to the ones
+// of RuntimeFileName, so that e can be written to a file without the
+// prologue. file is the path of the file of e, relative to the directory of
+// RuntimeFileName, e.g. "mychart/templates/a.yaml.libsonnet".
+func ImportRuntime(e *Expr, file string) *Expr {
+	used := e.Identifiers()
+	binds := []*LocalBind{}
+	for _, name := range runtimeFunctions() {
+		if used[name] {
+			binds = append(binds, &LocalBind{Name: name, Body: Index(runtimeName, name)})
+		}
+	}
+	if len(binds) == 0 {
+		return e
+	}
+	return &Expr{
+		Kind: ELocal,
+		LocalBinds: []*LocalBind{
+			{Name: runtimeName, Body: Import(RelativePath(file, RuntimeFileName))},
+		},
+		LocalBody: &Expr{Kind: ELocal, LocalBinds: binds, LocalBody: e},
+	}
+}
+
+// Import returns the expression that imports the file at path.
+func Import(path string) *Expr {
+	return &Expr{Kind: EImport, StringLiteral: path}
+}
+
+// RelativePath returns the path of target relative to the directory of
+// file, where both are relative to the same directory.
+func RelativePath(file, target string) string {
+	depth := 0
+	if dir := path.Dir(file); dir != "." {
+		depth = strings.Count(dir, "/") + 1
+	}
+	return strings.Repeat("../", depth) + target
+}
+
+// Identifiers returns the identifiers that e refers to or binds. The ones in
+// ERaw are included only if ERaw is an identifier.
+func (e *Expr) Identifiers() map[string]bool {
+	identifiers := map[string]bool{}
+	stack := []*Expr{e}
+	for len(stack) != 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch e.Kind {
+		case EID:
+			identifiers[e.IDName] = true
+		case ERaw:
+			if identifierRegexp.MatchString(e.Raw) {
+				identifiers[e.Raw] = true
+			}
+		}
+		for _, bind := range e.LocalBinds {
+			identifiers[bind.Name] = true
+		}
+		stack = append(stack, e.children()...)
+	}
+	return identifiers
+}
@@ -0,0 +1,144 @@
+package jsonnet
+
+import (
+	"sort"
+	"strings"
+)
+
+// The markers are Jsonnet comments: /*@LOCATION*/ begins the expression
+// compiled from LOCATION, and /*@*/ ends it.
+const (
+	locationMarkerBegin = "/*@"
+	locationMarkerEnd   = "*/"
+)
+
+type span struct {
+	begin, end int
+	location   string
+}
+
+// SourceMap maps positions in the compiled Jsonnet back to the locations in
+// the templates by the markers printed for Expr.Location.
+type SourceMap struct {
+	lineOffsets []int
+	// spans are sorted by begin, and the inner ones follow the outer ones.
+	spans []span
+}
+
+// locationMarker returns the marker that begins the expression compiled from
+// location, or the one that ends it if location is empty.
+func locationMarker(location string) string {
+	return locationMarkerBegin + strings.ReplaceAll(location, "*/", "* /") + locationMarkerEnd
+}
+
+// NewSourceMap parses the markers in source, which is the output of
+// Expr.String, Expr.Pretty or their variants with the prologue. Strings and
+// the other comments are skipped.
+func NewSourceMap(source string) *SourceMap {
+	m := &SourceMap{lineOffsets: []int{0}}
+	for i, ch := range source {
+		if ch == '\n' {
+			m.lineOffsets = append(m.lineOffsets, i+1)
+		}
+	}
+
+	stack := []int{}
+	for i := 0; i < len(source); {
+		rest := source[i:]
+		switch {
+		case strings.HasPrefix(rest, locationMarkerBegin):
+			begin := i + len(locationMarkerBegin)
+			i = skipPast(source, begin, locationMarkerEnd)
+			location := strings.TrimSuffix(source[begin:i], locationMarkerEnd)
+			if location != "" {
+				stack = append(stack, len(m.spans))
+				m.spans = append(m.spans, span{begin: i, end: -1, location: location})
+				continue
+			}
+			if len(stack) != 0 {
+				m.spans[stack[len(stack)-1]].end = begin - len(locationMarkerBegin)
+				stack = stack[:len(stack)-1]
+			}
+		case strings.HasPrefix(rest, "/*"):
+			i = skipPast(source, i+2, "*/")
+		case strings.HasPrefix(rest, "//"), rest[0] == '#':
+			i = skipPast(source, i, "\n")
+		case strings.HasPrefix(rest, "|||"):
+			i = skipTextBlock(source, i)
+		case strings.HasPrefix(rest, `@"`), strings.HasPrefix(rest, "@'"):
+			i = skipString(source, i+2, rest[1], true)
+		case rest[0] == '"', rest[0] == '\'':
+			i = skipString(source, i+1, rest[0], false)
+		default:
+			i++
+		}
+	}
+
+	return m
+}
+
+// skipPast returns the offset just after the first end in source from offset
+// i, or the end of source if there's none.
+func skipPast(source string, i int, end string) int {
+	j := strings.Index(source[i:], end)
+	if j == -1 {
+		return len(source)
+	}
+	return i + j + len(end)
+}
+
+// skipString returns the offset just after the string that begins at i and
+// is closed by quote. In verbatim strings, quotes are escaped by doubling
+// them.
+func skipString(source string, i int, quote byte, verbatim bool) int {
+	for i < len(source) {
+		switch ch := source[i]; {
+		case ch == '\\' && !verbatim:
+			i += 2
+		case ch == quote && verbatim && i+1 < len(source) && source[i+1] == quote:
+			i += 2
+		case ch == quote:
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(source)
+}
+
+// skipTextBlock returns the offset just after the text block that begins at
+// i, which ends at the first line that begins with |||.
+func skipTextBlock(source string, i int) int {
+	for {
+		j := strings.IndexByte(source[i:], '\n')
+		if j == -1 {
+			return len(source)
+		}
+		i += j + 1
+		line := strings.TrimLeft(source[i:], " \t")
+		if strings.HasPrefix(line, "|||") {
+			return len(source) - len(line) + len("|||")
+		}
+	}
+}
+
+// Locate returns the location in the templates of the innermost expression
+// that contains the position in the Jsonnet, where line and column are
+// 1-based as go-jsonnet reports. It returns false if the position is out of
+// the compiled templates, e.g. in the prologue.
+func (m *SourceMap) Locate(line, column int) (string, bool) {
+	if line < 1 || line > len(m.lineOffsets) {
+		return "", false
+	}
+	offset := m.lineOffsets[line-1] + column - 1
+
+	// The spans that begin before offset, among which the last one that
+	// contains offset is the innermost.
+	n := sort.Search(len(m.spans), func(i int) bool { return m.spans[i].begin > offset })
+	for i := n - 1; i >= 0; i-- {
+		if s := m.spans[i]; s.end == -1 || offset < s.end {
+			return s.location, true
+		}
+	}
+	return "", false
+}
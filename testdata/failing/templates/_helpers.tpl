@@ -0,0 +1,3 @@
+{{- define "failing.password" -}}
+{{ required "password is required" .Values.password }}
+{{- end }}
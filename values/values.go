@@ -0,0 +1,133 @@
+// Package values builds the values to render charts with from the sources
+// that Helm accepts, i.e., values files, --set, --set-string and so on. The
+// result is passed to the compiled Jsonnet as its values parameter, and the
+// chart's default values are coalesced with it in Jsonnet as Helm does. A null
+// there removes the corresponding default value.
+package values
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/strvals"
+	"sigs.k8s.io/yaml"
+)
+
+// Options are the sources of values, which correspond to the flags of helm
+// template. cf. values.Options
+type Options struct {
+	// ValueFiles are the values files in YAML (-f/--values). "-" means
+	// Stdin.
+	ValueFiles []string
+	// JSONValues are of the form key=json (--set-json).
+	JSONValues []string
+	// Values are of the form key1=val1,key2=val2 (--set).
+	Values []string
+	// StringValues are the same as Values, but the values are always
+	// strings (--set-string).
+	StringValues []string
+	// FileValues are of the form key=path, and the values are the contents
+	// of the files (--set-file).
+	FileValues []string
+	// LiteralValues are of the form key=value, and the value is a string
+	// as it is (--set-literal).
+	LiteralValues []string
+	// Stdin is read for the values file "-". nil means os.Stdin.
+	Stdin io.Reader
+}
+
+// MergeValues builds the values from the sources in the same order as Helm:
+// the values files are merged in order, and then --set-json, --set,
+// --set-string, --set-file and --set-literal are applied in this order.
+// cf. values.Options.MergeValues
+func (o *Options) MergeValues() (map[string]any, error) {
+	base := map[string]any{}
+
+	for _, filePath := range o.ValueFiles {
+		data, err := o.readFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		current := map[string]any{}
+		if err := yaml.Unmarshal(data, &current); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+		}
+		base = mergeMaps(base, current)
+	}
+
+	for _, value := range o.JSONValues {
+		if err := strvals.ParseJSON(value, base); err != nil {
+			return nil, fmt.Errorf("failed parsing --set-json data %s: %w", value, err)
+		}
+	}
+
+	for _, value := range o.Values {
+		if err := strvals.ParseInto(value, base); err != nil {
+			return nil, fmt.Errorf("failed parsing --set data: %w", err)
+		}
+	}
+
+	for _, value := range o.StringValues {
+		if err := strvals.ParseIntoString(value, base); err != nil {
+			return nil, fmt.Errorf("failed parsing --set-string data: %w", err)
+		}
+	}
+
+	for _, value := range o.FileValues {
+		reader := func(rs []rune) (any, error) {
+			data, err := o.readFile(string(rs))
+			if err != nil {
+				return nil, err
+			}
+			return string(data), nil
+		}
+		if err := strvals.ParseIntoFile(value, base, reader); err != nil {
+			return nil, fmt.Errorf("failed parsing --set-file data: %w", err)
+		}
+	}
+
+	for _, value := range o.LiteralValues {
+		if err := strvals.ParseLiteralInto(value, base); err != nil {
+			return nil, fmt.Errorf("failed parsing --set-literal data: %w", err)
+		}
+	}
+
+	return base, nil
+}
+
+// readFile reads a local file, or Stdin if filePath is "-". Unlike Helm, URLs
+// are not supported so that no network access is needed.
+func (o *Options) readFile(filePath string) ([]byte, error) {
+	if strings.TrimSpace(filePath) == "-" {
+		stdin := o.Stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(filePath)
+}
+
+// mergeMaps merges b into a recursively. The values in b win, including nulls,
+// which are kept so that they remove the default values later.
+// cf. values.mergeMaps
+func mergeMaps(a, b map[string]any) map[string]any {
+	out := make(map[string]any, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]any); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]any); ok {
+					out[k] = mergeMaps(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
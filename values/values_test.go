@@ -0,0 +1,146 @@
+package values_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushitora-anqou/helmhammer/values"
+)
+
+func TestMergeValues(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+	file0 := writeFile("0.yaml", "a: {b: 1, c: 2}\nd: 1\nlist: [1, 2]\n")
+	file1 := writeFile("1.yaml", "a: {b: null}\nd: {e: 1}\nlist: [3]\n")
+	content := writeFile("content.txt", "line1\nline2\n")
+
+	tests := []struct {
+		name     string
+		opts     values.Options
+		expected map[string]any
+	}{
+		{
+			name:     "empty",
+			expected: map[string]any{},
+		},
+		{
+			name: "values files are merged in order with nulls kept",
+			opts: values.Options{ValueFiles: []string{file0, file1}},
+			expected: map[string]any{
+				"a":    map[string]any{"b": nil, "c": float64(2)},
+				"d":    map[string]any{"e": float64(1)},
+				"list": []any{float64(3)},
+			},
+		},
+		{
+			name: "stdin",
+			opts: values.Options{ValueFiles: []string{"-"}, Stdin: strings.NewReader("a: 1\n")},
+			expected: map[string]any{
+				"a": float64(1),
+			},
+		},
+		{
+			name: "--set",
+			opts: values.Options{
+				Values: []string{"a.b[0].c=x,n=1,t=true,z=null", "list[1]=y", `e=a\,b`},
+			},
+			expected: map[string]any{
+				"a":    map[string]any{"b": []any{map[string]any{"c": "x"}}},
+				"n":    int64(1),
+				"t":    true,
+				"z":    nil,
+				"list": []any{nil, "y"},
+				"e":    "a,b",
+			},
+		},
+		{
+			name: "--set overrides values files",
+			opts: values.Options{
+				ValueFiles: []string{file0},
+				Values:     []string{"a.b=null,a.x=1"},
+			},
+			expected: map[string]any{
+				"a":    map[string]any{"b": nil, "c": float64(2), "x": int64(1)},
+				"d":    float64(1),
+				"list": []any{float64(1), float64(2)},
+			},
+		},
+		{
+			name: "the order of the sources",
+			opts: values.Options{
+				LiteralValues: []string{"literal=1,2"},
+				FileValues:    []string{"file=" + content, "literal=" + content},
+				StringValues:  []string{"string=1", "file=1", "literal=1"},
+				Values:        []string{"set=1,string=1,file=1,literal=1"},
+				JSONValues:    []string{`json={"x": [1]}`, "set=[]", "string=[]", "file=[]", "literal=[]"},
+			},
+			expected: map[string]any{
+				"json":    map[string]any{"x": []any{float64(1)}},
+				"set":     int64(1),
+				"string":  "1",
+				"file":    "line1\nline2\n",
+				"literal": "1,2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.MergeValues()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestMergeValuesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalid, []byte("a: [\n"), 0o644))
+
+	tests := []struct {
+		name, expectedError string
+		opts                values.Options
+	}{
+		{
+			name:          "missing values file",
+			opts:          values.Options{ValueFiles: []string{filepath.Join(dir, "missing.yaml")}},
+			expectedError: "no such file or directory",
+		},
+		{
+			name:          "invalid values file",
+			opts:          values.Options{ValueFiles: []string{invalid}},
+			expectedError: "failed to parse " + invalid,
+		},
+		{
+			name:          "invalid --set",
+			opts:          values.Options{Values: []string{"a"}},
+			expectedError: "failed parsing --set data",
+		},
+		{
+			name:          "invalid --set-json",
+			opts:          values.Options{JSONValues: []string{"a={"}},
+			expectedError: "failed parsing --set-json data",
+		},
+		{
+			name:          "missing --set-file",
+			opts:          values.Options{FileValues: []string{"a=" + filepath.Join(dir, "missing.txt")}},
+			expectedError: "failed parsing --set-file data",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.opts.MergeValues()
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-openapi/jsonpointer"
+	"sigs.k8s.io/yaml"
+)
+
+// IgnoreRule is a rule to ignore known differences of the manifests, such as
+// ones caused by the different output of toYaml.
+type IgnoreRule struct {
+	// Resource selects the manifests to apply the rule to by Key, e.g.
+	// "apps/v1 Deployment default/foo". Empty selects all the manifests.
+	Resource string `json:"resource,omitempty"`
+	// YAMLPaths are JSON pointers to strings that contain YAML, e.g.
+	// "/data/config.yaml". The strings are parsed before being compared, so
+	// that only the structural differences are reported.
+	YAMLPaths []string `json:"yamlPaths,omitempty"`
+	// Patch is a JSON patch applied to both sides after YAMLPaths are parsed,
+	// e.g. `[{"op": "remove", "path": "/metadata/annotations/checksum~1config"}]`.
+	// Removing missing fields is not an error.
+	Patch json.RawMessage `json:"patch,omitempty"`
+}
+
+// FieldDifference is a field that differs.
+type FieldDifference struct {
+	// Pointer is a JSON pointer to the field, e.g. "/spec/replicas". It's
+	// empty if the whole manifest is rendered by only one side.
+	Pointer string
+	// Helm and Helmhammer are the values of the field in JSON, which are nil
+	// if the field is missing.
+	Helm, Helmhammer json.RawMessage
+}
+
+// Difference is a manifest that differs between Helm and helmhammer.
+type Difference struct {
+	// Resource identifies the manifest. cf. Key
+	Resource string
+	Fields   []FieldDifference
+}
+
+func (d *Difference) String() string {
+	if len(d.Fields) == 1 && d.Fields[0].Pointer == "" {
+		if d.Fields[0].Helmhammer == nil {
+			return "only in Helm: " + d.Resource
+		}
+		if d.Fields[0].Helm == nil {
+			return "only in helmhammer: " + d.Resource
+		}
+	}
+
+	show := func(value json.RawMessage) string {
+		if value == nil {
+			return "(missing)"
+		}
+		return string(value)
+	}
+	var b strings.Builder
+	b.WriteString("differs: " + d.Resource)
+	for _, field := range d.Fields {
+		fmt.Fprintf(&b, "\n  %s:\n    Helm:       %s\n    helmhammer: %s",
+			field.Pointer, show(field.Helm), show(field.Helmhammer))
+	}
+	return b.String()
+}
+
+// Key identifies a manifest by its apiVersion, kind, namespace and name, e.g.
+// "apps/v1 Deployment default/foo". The namespace is omitted if it's empty.
+func Key(manifest map[string]any) string {
+	metadata, _ := manifest["metadata"].(map[string]any)
+	namespace, name := "", ""
+	if metadata != nil {
+		namespace, _ = metadata["namespace"].(string)
+		name, _ = metadata["name"].(string)
+	}
+	if namespace != "" {
+		name = namespace + "/" + name
+	}
+	return fmt.Sprintf("%v %v %s", manifest["apiVersion"], manifest["kind"], name)
+}
+
+// Compare compares the manifests rendered by Helm and helmhammer regardless of
+// their order, and returns the differences sorted by Key. The manifests that
+// have the same key are compared in order.
+func Compare(helm, helmhammer []map[string]any, rules []IgnoreRule) ([]Difference, error) {
+	patches := make([]jsonpatch.Patch, len(rules))
+	for i, rule := range rules {
+		if rule.Patch == nil {
+			continue
+		}
+		patch, err := jsonpatch.DecodePatch(rule.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch of ignore rule %d: %w", i, err)
+		}
+		patches[i] = patch
+	}
+
+	group := func(manifests []map[string]any) (map[string][]any, error) {
+		grouped := map[string][]any{}
+		for _, manifest := range manifests {
+			key := Key(manifest)
+			normalized, err := normalize(manifest)
+			if err != nil {
+				return nil, err
+			}
+			var applied any = normalized
+			for i, rule := range rules {
+				if rule.Resource != "" && rule.Resource != key {
+					continue
+				}
+				applied, err = applyIgnoreRule(applied, rule.YAMLPaths, patches[i])
+				if err != nil {
+					return nil, fmt.Errorf("failed to apply ignore rule %d to %s: %w", i, key, err)
+				}
+			}
+			grouped[key] = append(grouped[key], applied)
+		}
+		return grouped, nil
+	}
+	helmGroups, err := group(helm)
+	if err != nil {
+		return nil, err
+	}
+	helmhammerGroups, err := group(helmhammer)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for key := range helmGroups {
+		keys = append(keys, key)
+	}
+	for key := range helmhammerGroups {
+		if _, ok := helmGroups[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	diffs := []Difference{}
+	for _, key := range keys {
+		helmManifests, helmhammerManifests := helmGroups[key], helmhammerGroups[key]
+		for i := 0; i < max(len(helmManifests), len(helmhammerManifests)); i++ {
+			fields := []FieldDifference{}
+			switch {
+			case i >= len(helmhammerManifests):
+				fields = append(fields, fieldDifference("", helmManifests[i], true, nil, false))
+			case i >= len(helmManifests):
+				fields = append(fields, fieldDifference("", nil, false, helmhammerManifests[i], true))
+			default:
+				fields = diffValues("", helmManifests[i], helmhammerManifests[i], fields)
+			}
+			if len(fields) != 0 {
+				diffs = append(diffs, Difference{Resource: key, Fields: fields})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// applyIgnoreRule parses the YAML at yamlPaths in manifest and applies patch
+// to it.
+func applyIgnoreRule(manifest any, yamlPaths []string, patch jsonpatch.Patch) (any, error) {
+	for _, path := range yamlPaths {
+		pointer, err := jsonpointer.New(path)
+		if err != nil {
+			return nil, err
+		}
+		value, _, err := pointer.Get(manifest)
+		if err != nil {
+			// The field is missing on this side, which will be reported.
+			continue
+		}
+		src, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var parsed any
+		if err := yaml.Unmarshal([]byte(src), &parsed); err != nil {
+			// Leave it as it is so that the strings are compared.
+			continue
+		}
+		if manifest, err = pointer.Set(manifest, parsed); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, op := range patch {
+		if op.Kind() == "remove" {
+			path, err := op.Path()
+			if err != nil {
+				return nil, err
+			}
+			pointer, err := jsonpointer.New(path)
+			if err != nil {
+				return nil, err
+			}
+			if _, _, err := pointer.Get(manifest); err != nil {
+				continue
+			}
+		}
+		doc, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = (jsonpatch.Patch{op}).Apply(doc); err != nil {
+			return nil, err
+		}
+		manifest = nil
+		if err := json.Unmarshal(doc, &manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// diffValues appends the differences between helm and helmhammer at pointer
+// to diffs. Objects and arrays are compared recursively.
+func diffValues(pointer string, helm, helmhammer any, diffs []FieldDifference) []FieldDifference {
+	switch helm := helm.(type) {
+	case map[string]any:
+		helmhammer, ok := helmhammer.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for key := range helm {
+			keys = append(keys, key)
+		}
+		for key := range helmhammer {
+			if _, ok := helm[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPointer := pointer + "/" + jsonpointer.Escape(key)
+			helmValue, helmOK := helm[key]
+			helmhammerValue, helmhammerOK := helmhammer[key]
+			if helmOK && helmhammerOK {
+				diffs = diffValues(childPointer, helmValue, helmhammerValue, diffs)
+			} else {
+				diffs = append(diffs, fieldDifference(
+					childPointer, helmValue, helmOK, helmhammerValue, helmhammerOK))
+			}
+		}
+		return diffs
+
+	case []any:
+		helmhammer, ok := helmhammer.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(helm), len(helmhammer)); i++ {
+			childPointer := pointer + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(helmhammer):
+				diffs = append(diffs, fieldDifference(childPointer, helm[i], true, nil, false))
+			case i >= len(helm):
+				diffs = append(diffs, fieldDifference(childPointer, nil, false, helmhammer[i], true))
+			default:
+				diffs = diffValues(childPointer, helm[i], helmhammer[i], diffs)
+			}
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(helm, helmhammer) {
+		diffs = append(diffs, fieldDifference(pointer, helm, true, helmhammer, true))
+	}
+	return diffs
+}
+
+func fieldDifference(pointer string, helm any, helmOK bool, helmhammer any, helmhammerOK bool) FieldDifference {
+	marshal := func(value any, ok bool) json.RawMessage {
+		if !ok {
+			return nil
+		}
+		data, _ := json.Marshal(value)
+		return data
+	}
+	return FieldDifference{
+		Pointer:    pointer,
+		Helm:       marshal(helm, helmOK),
+		Helmhammer: marshal(helmhammer, helmhammerOK),
+	}
+}
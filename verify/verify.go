@@ -0,0 +1,248 @@
+// Package verify renders a chart with both Helm's template engine and the
+// Jsonnet compiled by helmhammer, and compares the manifests.
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ushitora-anqou/helmhammer"
+	"github.com/ushitora-anqou/helmhammer/helm"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultKubeVersion is the Kubernetes version used when Options.KubeVersion
+// is empty. It's the default of the compiled Jsonnet.
+const DefaultKubeVersion = helmhammer.DefaultKubeVersion
+
+// Options are the options to render charts, which correspond to the ones of
+// helm template.
+type Options struct {
+	// RepositoryDirs are the local directories to resolve missing
+	// dependencies from. cf. helm.LoadOptions
+	RepositoryDirs []string
+	// Values are the values given by the user, which are coalesced with the
+	// values of the chart. cf. values.Options.MergeValues
+	Values map[string]any
+	// Namespace is the namespace of the release. Empty means "default".
+	Namespace string
+	// ReleaseName is the name of the release. Empty means the chart name.
+	ReleaseName string
+	// KubeVersion is .Capabilities.KubeVersion. Empty means DefaultKubeVersion.
+	KubeVersion string
+	// APIVersions are added to .Capabilities.APIVersions.
+	APIVersions []string
+	IsUpgrade   bool
+	IncludeCrds bool
+	// Ignore are the rules to ignore known differences.
+	Ignore []IgnoreRule
+}
+
+func (o *Options) namespace() string {
+	if o.Namespace == "" {
+		return "default"
+	}
+	return o.Namespace
+}
+
+func (o *Options) releaseName(chartName string) string {
+	if o.ReleaseName == "" {
+		return chartName
+	}
+	return o.ReleaseName
+}
+
+func (o *Options) kubeVersion() string {
+	if o.KubeVersion == "" {
+		return DefaultKubeVersion
+	}
+	return o.KubeVersion
+}
+
+// Result is the result of Verify.
+type Result struct {
+	// Manifests is the number of the manifests rendered by Helm.
+	Manifests int
+	// Differences are empty if the manifests are identical.
+	Differences []Difference
+}
+
+// Verify renders the chart at chartPath with Helm and helmhammer, and
+// compares the manifests.
+func Verify(chartPath string, opts *Options) (*Result, error) {
+	loadOpts := &helm.LoadOptions{RepositoryDirs: opts.RepositoryDirs}
+
+	// The chart is loaded twice because Helm modifies it while rendering.
+	rootChart, err := helm.LoadWithOptions(chartPath, loadOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+	helmChart, err := helm.LoadHelmChart(chartPath, loadOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+
+	actual, err := RenderWithHelmhammer(rootChart, opts)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := RenderWithHelm(helmChart, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs, err := Compare(expected, actual, opts.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Manifests: len(expected), Differences: diffs}, nil
+}
+
+// RenderWithHelm renders chart in-process with Helm's template engine as helm
+// template does, including hooks and tests. chart is modified as Helm does
+// while rendering. cf. action.Install.RunWithContext
+func RenderWithHelm(chart *helmchart.Chart, opts *Options) ([]map[string]any, error) {
+	values := opts.Values
+	if values == nil {
+		values = map[string]any{}
+	}
+
+	if err := chartutil.ProcessDependenciesWithMerge(chart, values); err != nil {
+		return nil, fmt.Errorf("failed to process dependencies: %w", err)
+	}
+
+	caps := chartutil.DefaultCapabilities.Copy()
+	kubeVersion, err := chartutil.ParseKubeVersion(opts.kubeVersion())
+	if err != nil {
+		return nil, fmt.Errorf("invalid kube version: %w", err)
+	}
+	caps.KubeVersion = *kubeVersion
+	caps.APIVersions = append(caps.APIVersions, opts.APIVersions...)
+	if chart.Metadata.KubeVersion != "" &&
+		!chartutil.IsCompatibleRange(chart.Metadata.KubeVersion, caps.KubeVersion.String()) {
+		return nil, fmt.Errorf(
+			"chart requires kubeVersion: %s which is incompatible with Kubernetes %s",
+			chart.Metadata.KubeVersion, caps.KubeVersion.String(),
+		)
+	}
+
+	renderValues, err := chartutil.ToRenderValues(chart, values, chartutil.ReleaseOptions{
+		Name:      opts.releaseName(chart.Name()),
+		Namespace: opts.namespace(),
+		Revision:  1,
+		IsInstall: !opts.IsUpgrade,
+		IsUpgrade: opts.IsUpgrade,
+	}, caps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build values: %w", err)
+	}
+
+	files, err := engine.Render(chart, renderValues)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render chart with Helm: %w", err)
+	}
+	for path := range files {
+		if strings.HasSuffix(path, "NOTES.txt") {
+			delete(files, path)
+		}
+	}
+	hooks, manifests, err := releaseutil.SortManifests(files, nil, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the manifests rendered by Helm: %w", err)
+	}
+
+	docs := []string{}
+	if opts.IncludeCrds {
+		for _, crd := range chart.CRDObjects() {
+			docs = append(docs, string(crd.File.Data))
+		}
+	}
+	for _, manifest := range manifests {
+		docs = append(docs, manifest.Content)
+	}
+	for _, hook := range hooks {
+		docs = append(docs, hook.Manifest)
+	}
+
+	result := []map[string]any{}
+	for _, doc := range docs {
+		parsed, err := parseManifests(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the manifests rendered by Helm: %w", err)
+		}
+		result = append(result, parsed...)
+	}
+	return result, nil
+}
+
+// parseManifests parses YAML documents into manifests. Documents that have
+// only whitespace or comments are skipped, but {} isn't as helm template prints
+// it.
+func parseManifests(src string) ([]map[string]any, error) {
+	docs := releaseutil.SplitManifests(src)
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	manifests := []map[string]any{}
+	for _, key := range keys {
+		var manifest map[string]any
+		if err := yaml.Unmarshal([]byte(docs[key]), &manifest); err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			manifests = append(manifests, manifest)
+		}
+	}
+	return manifests, nil
+}
+
+// RenderWithHelmhammer compiles chart into Jsonnet and evaluates it with
+// go-jsonnet. cf. helmhammer.Artifact.Render
+func RenderWithHelmhammer(chart *helm.RootChart, opts *Options) ([]map[string]any, error) {
+	artifact, err := helmhammer.CompileChart(chart, helmhammer.Options{
+		KubeVersion: opts.KubeVersion,
+		APIVersions: opts.APIVersions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := artifact.Render(context.Background(), helmhammer.RenderOptions{
+		Values:      opts.Values,
+		Namespace:   opts.Namespace,
+		ReleaseName: opts.ReleaseName,
+		IsUpgrade:   opts.IsUpgrade,
+		IncludeCrds: opts.IncludeCrds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]map[string]any, 0, len(rendered))
+	for _, manifest := range rendered {
+		manifests = append(manifests, manifest.Object)
+	}
+	return manifests, nil
+}
+
+// normalize converts v into the values that encoding/json produces, i.e.,
+// numbers are float64 and so on.
+func normalize[T any](v T) (T, error) {
+	var normalized T
+	data, err := json.Marshal(v)
+	if err != nil {
+		return normalized, err
+	}
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
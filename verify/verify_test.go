@@ -0,0 +1,255 @@
+package verify_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushitora-anqou/helmhammer/verify"
+	"sigs.k8s.io/yaml"
+)
+
+func TestVerify(t *testing.T) {
+	compilerTestdataDir := "../compiler/testdata"
+
+	tests := []struct {
+		name, chartDir, valuesYaml string
+		opts                       verify.Options
+		expectedManifests          int
+	}{
+		{name: "skeleton", chartDir: "skeleton", expectedManifests: 4},
+		{
+			name:              "values",
+			chartDir:          "testchart",
+			valuesYaml:        "testchart.values.yaml",
+			expectedManifests: 3,
+		},
+		{
+			name:     "release",
+			chartDir: "release",
+			opts: verify.Options{
+				Namespace:   "ns",
+				ReleaseName: "foo",
+				IsUpgrade:   true,
+				Values:      map[string]any{"password": ""},
+			},
+			expectedManifests: 1,
+		},
+		{
+			name:     "capabilities",
+			chartDir: "capabilities",
+			opts: verify.Options{
+				KubeVersion: "v1.29.3-gke.1200",
+				APIVersions: []string{"monitoring.coreos.com/v1"},
+			},
+			expectedManifests: 2,
+		},
+		{
+			name:              "crds",
+			chartDir:          "crds",
+			valuesYaml:        "crds-1.values.yaml",
+			opts:              verify.Options{IncludeCrds: true},
+			expectedManifests: 8,
+		},
+		{name: "hooks", chartDir: "hooks", expectedManifests: 8},
+		{
+			name:              "dependencies from repositories",
+			chartDir:          "vendoring",
+			opts:              verify.Options{RepositoryDirs: []string{"../compiler/testdata/repository"}},
+			expectedManifests: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			if tt.valuesYaml != "" {
+				valuesYaml, err := os.ReadFile(filepath.Join(compilerTestdataDir, tt.valuesYaml))
+				require.NoError(t, err)
+				require.NoError(t, yaml.Unmarshal(valuesYaml, &opts.Values))
+			}
+
+			result, err := verify.Verify(filepath.Join(compilerTestdataDir, tt.chartDir), &opts)
+			require.NoError(t, err)
+			assert.Empty(t, result.Differences)
+			assert.Equal(t, tt.expectedManifests, result.Manifests)
+		})
+	}
+}
+
+func TestVerifyIgnoreRules(t *testing.T) {
+	// toYaml of helmhammer quotes strings differently from Helm.
+	result, err := verify.Verify("testdata/toyaml", &verify.Options{})
+	require.NoError(t, err)
+	require.Len(t, result.Differences, 1)
+	assert.Equal(t, "v1 ConfigMap toyaml", result.Differences[0].Resource)
+	require.Len(t, result.Differences[0].Fields, 1)
+	assert.Equal(t, "/data/config.yaml", result.Differences[0].Fields[0].Pointer)
+
+	for _, rule := range []verify.IgnoreRule{
+		{YAMLPaths: []string{"/data/config.yaml"}},
+		{Resource: "v1 ConfigMap toyaml", YAMLPaths: []string{"/data/config.yaml"}},
+		{Patch: json.RawMessage(`[{"op": "remove", "path": "/data/config.yaml"}]`)},
+	} {
+		result, err := verify.Verify("testdata/toyaml", &verify.Options{Ignore: []verify.IgnoreRule{rule}})
+		require.NoError(t, err)
+		assert.Empty(t, result.Differences)
+	}
+
+	// The rule doesn't select the ConfigMap.
+	result, err = verify.Verify("testdata/toyaml", &verify.Options{
+		Ignore: []verify.IgnoreRule{
+			{Resource: "v1 ConfigMap default/toyaml", YAMLPaths: []string{"/data/config.yaml"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Len(t, result.Differences, 1)
+}
+
+func TestCompare(t *testing.T) {
+	parse := func(src string) []map[string]any {
+		var manifests []map[string]any
+		require.NoError(t, yaml.Unmarshal([]byte(src), &manifests))
+		return manifests
+	}
+
+	tests := []struct {
+		name, helm, helmhammer string
+		rules                  []verify.IgnoreRule
+		expected               []verify.Difference
+	}{
+		{
+			name: "identical regardless of the order",
+			helm: `
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: a}, data: {k: v}}
+- {apiVersion: v1, kind: Secret, metadata: {name: a, namespace: ns}}
+`,
+			helmhammer: `
+- {apiVersion: v1, kind: Secret, metadata: {name: a, namespace: ns}}
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: a}, data: {k: v}}
+`,
+			expected: []verify.Difference{},
+		},
+		{
+			name: "fields",
+			helm: `
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata: {name: a, namespace: ns, labels: {a/b: x, c~d: w}}
+  spec: {replicas: 1, args: [a, b, c], template: {foo: 1}}
+`,
+			helmhammer: `
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata: {name: a, namespace: ns, labels: {a/b: z}}
+  spec: {replicas: 1.0, args: [a, x], template: [1], extra: null}
+`,
+			expected: []verify.Difference{
+				{
+					Resource: "apps/v1 Deployment ns/a",
+					Fields: []verify.FieldDifference{
+						{Pointer: "/metadata/labels/a~1b", Helm: json.RawMessage(`"x"`), Helmhammer: json.RawMessage(`"z"`)},
+						{Pointer: "/metadata/labels/c~0d", Helm: json.RawMessage(`"w"`)},
+						{Pointer: "/spec/args/1", Helm: json.RawMessage(`"b"`), Helmhammer: json.RawMessage(`"x"`)},
+						{Pointer: "/spec/args/2", Helm: json.RawMessage(`"c"`)},
+						{Pointer: "/spec/extra", Helmhammer: json.RawMessage(`null`)},
+						{Pointer: "/spec/template", Helm: json.RawMessage(`{"foo":1}`), Helmhammer: json.RawMessage(`[1]`)},
+					},
+				},
+			},
+		},
+		{
+			name: "missing manifests",
+			helm: `
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: a}}
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: a}}
+`,
+			helmhammer: `
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: a}}
+- {apiVersion: v1, kind: Secret, metadata: {name: a}}
+`,
+			expected: []verify.Difference{
+				{
+					Resource: "v1 ConfigMap a",
+					Fields: []verify.FieldDifference{
+						{Helm: json.RawMessage(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"a"}}`)},
+					},
+				},
+				{
+					Resource: "v1 Secret a",
+					Fields: []verify.FieldDifference{
+						{Helmhammer: json.RawMessage(`{"apiVersion":"v1","kind":"Secret","metadata":{"name":"a"}}`)},
+					},
+				},
+			},
+		},
+		{
+			name: "ignore rules",
+			helm: `
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: a, annotations: {checksum: "1"}}, data: {c: "a: 'x'\nb: [1]\n", d: "a: 1"}}
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: b, annotations: {checksum: "1"}}, data: {c: "not: [yaml"}}
+`,
+			helmhammer: `
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: a, annotations: {checksum: "2"}}, data: {c: "a: \"x\"\nb:\n- 1", d: "a: 2"}}
+- {apiVersion: v1, kind: ConfigMap, metadata: {name: b, annotations: {}}, data: {c: "not: [yaml]"}}
+`,
+			rules: []verify.IgnoreRule{
+				{Patch: json.RawMessage(`[{"op": "remove", "path": "/metadata/annotations/checksum"}]`)},
+				{Resource: "v1 ConfigMap a", YAMLPaths: []string{"/data/c", "/data/d", "/data/missing"}},
+				{Resource: "v1 ConfigMap b", YAMLPaths: []string{"/data/c"}},
+			},
+			expected: []verify.Difference{
+				{
+					Resource: "v1 ConfigMap a",
+					Fields: []verify.FieldDifference{
+						{Pointer: "/data/d/a", Helm: json.RawMessage(`1`), Helmhammer: json.RawMessage(`2`)},
+					},
+				},
+				{
+					Resource: "v1 ConfigMap b",
+					Fields: []verify.FieldDifference{
+						{Pointer: "/data/c", Helm: json.RawMessage(`"not: [yaml"`), Helmhammer: json.RawMessage(`{"not":["yaml"]}`)},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := verify.Compare(parse(tt.helm), parse(tt.helmhammer), tt.rules)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, diffs)
+		})
+	}
+
+	_, err := verify.Compare(nil, nil, []verify.IgnoreRule{{Patch: json.RawMessage(`{}`)}})
+	assert.ErrorContains(t, err, "invalid patch of ignore rule 0")
+}
+
+func TestDifferenceString(t *testing.T) {
+	assert.Equal(t, "only in Helm: v1 ConfigMap a", (&verify.Difference{
+		Resource: "v1 ConfigMap a",
+		Fields:   []verify.FieldDifference{{Helm: json.RawMessage(`{}`)}},
+	}).String())
+	assert.Equal(t, "only in helmhammer: v1 ConfigMap a", (&verify.Difference{
+		Resource: "v1 ConfigMap a",
+		Fields:   []verify.FieldDifference{{Helmhammer: json.RawMessage(`{}`)}},
+	}).String())
+	assert.Equal(t, `differs: v1 ConfigMap a
+  /data/a:
+    Helm:       "x"
+    helmhammer: (missing)
+  /data/b:
+    Helm:       (missing)
+    helmhammer: 1`, (&verify.Difference{
+		Resource: "v1 ConfigMap a",
+		Fields: []verify.FieldDifference{
+			{Pointer: "/data/a", Helm: json.RawMessage(`"x"`)},
+			{Pointer: "/data/b", Helmhammer: json.RawMessage(`1`)},
+		},
+	}).String())
+}